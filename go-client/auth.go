@@ -0,0 +1,270 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 接口鉴权：除前端页面和 /ping 外，所有接口都需要在 Authorization 头中携带令牌。
+// 令牌只在创建时返回一次，数据库中只保存 sha256 哈希。
+
+// 令牌权限，admin 包含 write，write 包含 read。read 和 write 只能访问令牌所属用户的数据
+const (
+	ScopeRead  = "read"  // 搜索、查看对象和文件夹
+	ScopeWrite = "write" // 上传、修改和删除
+	ScopeAdmin = "admin" // 服务管理：所有用户的向量库维护、用户和令牌管理
+)
+
+var scopeLevels = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// 令牌前缀，便于在配置文件或日志中识别
+const tokenPrefix = "igt_"
+
+type APIToken struct {
+	ID         int        `json:"id"`
+	UserID     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+// 校验并规范化权限列表，为空时默认 read
+func parseScopes(scopes []string) ([]string, error) {
+	var result []string
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		scope = strings.ToLower(strings.TrimSpace(scope))
+		if scope == "" || seen[scope] {
+			continue
+		}
+		if _, ok := scopeLevels[scope]; !ok {
+			return nil, validationError(fmt.Sprintf("无效的权限 '%s'，应为 read、write 或 admin", scope))
+		}
+		seen[scope] = true
+		result = append(result, scope)
+	}
+	if len(result) == 0 {
+		result = []string{ScopeRead}
+	}
+	return result, nil
+}
+
+// 令牌是否具有所需权限
+func hasScope(scopes []string, required string) bool {
+	for _, scope := range scopes {
+		if scopeLevels[scope] >= scopeLevels[required] {
+			return true
+		}
+	}
+	return false
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// 为用户生成新令牌，返回明文令牌，之后无法再次获取
+func createAPIToken(userID int, name string, scopes []string) (string, APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return "", APIToken{}, validationError("令牌名称不能为空")
+	}
+	scopes, err := parseScopes(scopes)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	exists, err := userExists(userID)
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	if !exists {
+		return "", APIToken{}, validationError(fmt.Sprintf("用户 %d 不存在", userID))
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", APIToken{}, err
+	}
+	token := tokenPrefix + hex.EncodeToString(secret)
+
+	result, err := db.Exec("INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at) VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)",
+		userID, name, hashToken(token), strings.Join(scopes, ","))
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return "", APIToken{}, err
+	}
+	return token, APIToken{ID: int(id), UserID: userID, Name: name, Scopes: scopes, CreatedAt: time.Now().UTC()}, nil
+}
+
+func listAPITokens() ([]APIToken, error) {
+	rows, err := db.Query("SELECT id, user_id, name, scopes, created_at, last_used_at FROM api_tokens ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var token APIToken
+		var scopes string
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&token.ID, &token.UserID, &token.Name, &scopes, &token.CreatedAt, &lastUsedAt); err != nil {
+			return nil, err
+		}
+		token.Scopes = strings.Split(scopes, ",")
+		if lastUsedAt.Valid {
+			token.LastUsedAt = &lastUsedAt.Time
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// 吊销令牌
+func deleteAPIToken(id int) error {
+	result, err := db.Exec("DELETE FROM api_tokens WHERE id = ?", id)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 按明文令牌查找，不存在时返回 sql.ErrNoRows
+func lookupAPIToken(token string) (APIToken, error) {
+	var apiToken APIToken
+	var scopes string
+	var lastUsedAt sql.NullTime
+	err := db.QueryRow("SELECT id, user_id, name, scopes, created_at, last_used_at FROM api_tokens WHERE token_hash = ?", hashToken(token)).
+		Scan(&apiToken.ID, &apiToken.UserID, &apiToken.Name, &scopes, &apiToken.CreatedAt, &lastUsedAt)
+	if err != nil {
+		return apiToken, err
+	}
+	apiToken.Scopes = strings.Split(scopes, ",")
+	if lastUsedAt.Valid {
+		apiToken.LastUsedAt = &lastUsedAt.Time
+	}
+
+	// 最近使用时间只精确到分钟，避免每个请求都写数据库
+	db.Exec(`UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < datetime('now', '-1 minute'))`, apiToken.ID)
+	return apiToken, nil
+}
+
+// 可以用 access_token 参数携带令牌的路由。只有 <img> 需要，
+// URL中的令牌会留在浏览器历史、代理日志和 Referer 中，其他接口都必须使用请求头
+var queryTokenRoutes = map[string]bool{
+	"/objects/:id/image":     true,
+	"/objects/:id/thumbnail": true,
+}
+
+// 从请求中取出令牌，图片接口的 GET 请求也可以使用 access_token 参数
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+	if c.Request.Method == "GET" && queryTokenRoutes[c.FullPath()] {
+		return c.Query("access_token")
+	}
+	return ""
+}
+
+// 鉴权中间件，要求令牌具有指定权限
+func requireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if token == "" {
+			c.Header("WWW-Authenticate", `Bearer realm="instago"`)
+			c.AbortWithStatusJSON(401, gin.H{"error": "Missing bearer token"})
+			return
+		}
+
+		apiToken, err := lookupAPIToken(token)
+		if err == sql.ErrNoRows {
+			c.Header("WWW-Authenticate", `Bearer realm="instago", error="invalid_token"`)
+			c.AbortWithStatusJSON(401, gin.H{"error": "Invalid token"})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(500, gin.H{"error": fmt.Sprintf("Failed to check token: %v", err)})
+			return
+		}
+		if !hasScope(apiToken.Scopes, scope) {
+			c.AbortWithStatusJSON(403, gin.H{"error": fmt.Sprintf("Token does not have '%s' scope", scope)})
+			return
+		}
+
+		c.Set("api_token", apiToken)
+		c.Set("user_id", apiToken.UserID)
+		c.Next()
+	}
+}
+
+// 获取令牌列表处理器
+func listAPITokensHandler(c *gin.Context) {
+	tokens, err := listAPITokens()
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get tokens: %v", err)})
+		return
+	}
+	c.JSON(200, gin.H{"tokens": tokens})
+}
+
+// 创建令牌处理器，明文令牌只在响应中返回一次；不指定用户时属于当前用户
+func createAPITokenHandler(c *gin.Context) {
+	var req APITokenRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+	userID := currentUserID(c)
+	if req.UserID != nil {
+		userID = *req.UserID
+	}
+
+	token, apiToken, err := createAPIToken(userID, req.Name, req.Scopes)
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to create token: %v", err)})
+		return
+	}
+	c.JSON(200, gin.H{"token": token, "info": apiToken})
+}
+
+// 吊销令牌处理器
+func deleteAPITokenHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid token ID"})
+		return
+	}
+
+	err = deleteAPIToken(id)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Token not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to delete token: %v", err)})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Token deleted successfully"})
+}
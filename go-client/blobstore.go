@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 图片二进制按内容寻址存储在磁盘上：<BlobDir>/ab/cd/abcd...，数据库只保存哈希。
+// 引用图片的是 objects.blob_hash 和未完成任务的 jobs.blob_hash
+
+// 写入blob并登记引用、检查引用并删除blob 都在这个锁内完成，
+// 避免相同图片的新上传在检查之后、删除之前写入，随后被删掉
+var blobMu sync.Mutex
+
+// 解码上传的base64图片，兼容 data URL 前缀
+func decodeImageBlob(blob string) ([]byte, error) {
+	if i := strings.Index(blob, ";base64,"); i >= 0 && strings.HasPrefix(blob, "data:") {
+		blob = blob[i+len(";base64,"):]
+	}
+	return base64.StdEncoding.DecodeString(strings.TrimSpace(blob))
+}
+
+// 已存储的图片
+type Blob struct {
+	Hash     string `json:"hash"`
+	Size     int    `json:"size"`
+	MimeType string `json:"mime_type"`
+}
+
+// 计算blob在磁盘上的路径
+func blobPath(hash string) string {
+	return filepath.Join(config.BlobDir, hash[:2], hash[2:4], hash)
+}
+
+// 写入blob，以 sha256 哈希寻址；相同内容只存一份
+func putBlob(data []byte) (Blob, error) {
+	sum := sha256.Sum256(data)
+	blob := Blob{
+		Hash:     hex.EncodeToString(sum[:]),
+		Size:     len(data),
+		MimeType: http.DetectContentType(data),
+	}
+
+	path := blobPath(blob.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return blob, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return Blob{}, err
+	}
+
+	// 先写临时文件再重命名，避免并发或中断时留下不完整的blob
+	tmp, err := os.CreateTemp(filepath.Dir(path), blob.Hash+".tmp*")
+	if err != nil {
+		return Blob{}, err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return Blob{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return Blob{}, err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return Blob{}, err
+	}
+
+	return blob, nil
+}
+
+// 读取blob内容
+func readBlob(hash string) ([]byte, error) {
+	if len(hash) < 4 {
+		return nil, fmt.Errorf("invalid blob hash %q", hash)
+	}
+	return os.ReadFile(blobPath(hash))
+}
+
+// 将旧版本存在 objects.data 中的base64图片迁移到blob存储
+func migrateObjectBlobs() error {
+	var migrated int
+	lastID := -1
+	for {
+		// 分批处理，避免一次性把所有图片读入内存
+		rows, err := db.Query("SELECT id, data FROM objects WHERE blob_hash = '' AND data != '' AND id > ? ORDER BY id LIMIT 50", lastID)
+		if err != nil {
+			return err
+		}
+
+		type legacyRow struct {
+			id   int
+			data string
+		}
+		var batch []legacyRow
+		for rows.Next() {
+			var r legacyRow
+			if err := rows.Scan(&r.id, &r.data); err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+
+		if len(batch) == 0 {
+			break
+		}
+
+		for _, r := range batch {
+			lastID = r.id
+			data, err := decodeImageBlob(r.data)
+			if err != nil {
+				slog.Warn("图片数据无法解码，跳过迁移", "object_id", r.id, "err", err)
+				continue
+			}
+			blob, err := putBlob(data)
+			if err != nil {
+				return fmt.Errorf("迁移对象 %d 的图片失败: %v", r.id, err)
+			}
+			if _, err := db.Exec("UPDATE objects SET blob_hash = ?, blob_size = ?, mime_type = ?, data = '' WHERE id = ?",
+				blob.Hash, blob.Size, blob.MimeType, r.id); err != nil {
+				return err
+			}
+			migrated++
+		}
+	}
+
+	if migrated > 0 {
+		slog.Info("已将图片迁移到blob目录", "count", migrated, "blob_dir", config.BlobDir)
+		// 回收base64数据占用的空间
+		if _, err := db.Exec("VACUUM"); err != nil {
+			slog.Warn("VACUUM 失败", "err", err)
+		}
+	}
+	return nil
+}
+
+// 没有对象再引用时删除blob及其缩略图
+func releaseBlob(hash string) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+	return removeUnusedBlob(hash)
+}
+
+// 同 releaseBlob，调用方需持有 blobMu
+func removeUnusedBlob(hash string) error {
+	if len(hash) < 4 {
+		return nil
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM objects WHERE blob_hash = ?", hash).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	// 相同图片可能正在排队上传
+	if err := db.QueryRow("SELECT COUNT(*) FROM jobs WHERE blob_hash = ? AND status NOT IN (?, ?)",
+		hash, JobDone, JobFailed).Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	if err := os.Remove(blobPath(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	removeThumbnails(hash)
+	return nil
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// 命令行子命令，不带参数运行时启动服务
+//
+//	instago migrate          执行未完成的数据库迁移
+//	instago migrate status   查看迁移执行情况
+//	instago verify [--user <用户名>]           检查向量库与数据库是否一致，默认检查所有用户
+//	instago reindex [--all] [--user <用户名>]  修复不一致，--all 时重建整个向量集合
+//	instago user create <用户名>  创建用户及其根文件夹
+//	instago user list        列出用户
+//	instago token create <名称> [--user <用户名>] [--scopes read,write,admin]  创建接口令牌，默认属于 default 用户并拥有全部权限
+//	instago token list       列出令牌
+//	instago token revoke <ID> 吊销令牌
+//
+// verify 和 reindex 直接读写向量库目录，请在服务停止时执行，服务运行时使用 /admin/index 接口。
+// 命令结果输出到标准输出，日志输出到标准错误
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "verify", "reindex":
+		return indexCommand(args[0], args[1:])
+	case "user":
+		return userCommand(args[1:])
+	case "token":
+		return tokenCommand(args[1:])
+	default:
+		return fmt.Errorf("未知命令 '%s'，可用命令: migrate、verify、reindex、user、token", args[0])
+	}
+}
+
+// 解析 --名称 值 形式的选项，flags 中的选项不带值
+func parseOptions(args []string, options []string, flags []string) (map[string]string, error) {
+	known := map[string]bool{}
+	for _, option := range options {
+		known[option] = true
+	}
+	isFlag := map[string]bool{}
+	for _, flag := range flags {
+		isFlag[flag] = true
+	}
+
+	values := map[string]string{}
+	for i := 0; i < len(args); i++ {
+		switch {
+		case isFlag[args[i]]:
+			values[args[i]] = "true"
+		case known[args[i]] && i+1 < len(args):
+			values[args[i]] = args[i+1]
+			i++
+		default:
+			return nil, fmt.Errorf("未知参数 '%s'", args[i])
+		}
+	}
+	return values, nil
+}
+
+// 按用户名查找用户
+func findUserByName(name string) (User, error) {
+	user, err := getUserByName(name)
+	if err == sql.ErrNoRows {
+		return user, fmt.Errorf("用户 '%s' 不存在", name)
+	}
+	return user, err
+}
+
+const userUsage = "用法: instago user create <用户名> | user list"
+
+func userCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(userUsage)
+	}
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "create" && len(args) == 2:
+		user, err := createUser(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已创建用户 %d (%s)，根文件夹: %d\n", user.ID, user.Name, user.RootFolderID)
+		fmt.Printf("使用 instago token create <名称> --user %s 为该用户创建令牌\n", user.Name)
+		return nil
+	case args[0] == "list" && len(args) == 1:
+		users, err := listUsers()
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			fmt.Printf("%3d  %-20s 根文件夹: %d\n", user.ID, user.Name, user.RootFolderID)
+		}
+		return nil
+	default:
+		return fmt.Errorf(userUsage)
+	}
+}
+
+const tokenUsage = "用法: instago token create <名称> [--user <用户名>] [--scopes read,write,admin] | token list | token revoke <ID>"
+
+func tokenCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf(tokenUsage)
+	}
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch {
+	case args[0] == "create" && len(args) >= 2:
+		options, err := parseOptions(args[2:], []string{"--user", "--scopes"}, nil)
+		if err != nil {
+			return fmt.Errorf("%v，%s", err, tokenUsage)
+		}
+		user, err := getUser(DefaultUserID)
+		if name, ok := options["--user"]; ok {
+			user, err = findUserByName(name)
+		}
+		if err != nil {
+			return err
+		}
+		// 第一个令牌用于登录和签发其他令牌，默认拥有全部权限
+		scopes := []string{ScopeRead, ScopeWrite, ScopeAdmin}
+		if value, ok := options["--scopes"]; ok {
+			scopes = strings.Split(value, ",")
+		}
+		token, info, err := createAPIToken(user.ID, args[1], scopes)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("已为用户 %s 创建令牌 %d (%s)，权限: %s\n", user.Name, info.ID, info.Name, strings.Join(info.Scopes, ","))
+		fmt.Printf("%s\n", token)
+		fmt.Printf("令牌只显示这一次，请妥善保存\n")
+		return nil
+	case args[0] == "list" && len(args) == 1:
+		tokens, err := listAPITokens()
+		if err != nil {
+			return err
+		}
+		for _, token := range tokens {
+			lastUsed := "从未使用"
+			if token.LastUsedAt != nil {
+				lastUsed = token.LastUsedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%3d  %-20s 用户 %-4d %-18s %s\n", token.ID, token.Name, token.UserID, strings.Join(token.Scopes, ","), lastUsed)
+		}
+		return nil
+	case args[0] == "revoke" && len(args) == 2:
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			return fmt.Errorf("无效的令牌ID '%s'", args[1])
+		}
+		if err := deleteAPIToken(id); err == sql.ErrNoRows {
+			return fmt.Errorf("令牌 %d 不存在", id)
+		} else if err != nil {
+			return err
+		}
+		fmt.Printf("已吊销令牌 %d\n", id)
+		return nil
+	default:
+		return fmt.Errorf(tokenUsage)
+	}
+}
+
+func indexCommand(name string, args []string) error {
+	var flags []string
+	if name == "reindex" {
+		flags = []string{"--all"}
+	}
+	options, err := parseOptions(args, []string{"--user"}, flags)
+	if err != nil {
+		return fmt.Errorf("%v，用法: instago verify [--user <用户名>] 或 instago reindex [--all] [--user <用户名>]", err)
+	}
+	all := options["--all"] == "true"
+
+	if err := initDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+	if err := initFTS(); err != nil {
+		return err
+	}
+	if err := initVectorDB(); err != nil {
+		return err
+	}
+
+	userParam := ""
+	if userName, ok := options["--user"]; ok {
+		user, err := findUserByName(userName)
+		if err != nil {
+			return err
+		}
+		userParam = strconv.Itoa(user.ID)
+	}
+	userIDs, err := indexUserIDs(userParam)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var results []interface{}
+	for _, userID := range userIDs {
+		var result interface{}
+		switch {
+		case name == "verify":
+			result, err = verifyIndex(ctx, userID)
+		case all:
+			result, err = rebuildIndex(ctx, userID)
+		default:
+			result, err = repairIndex(ctx, userID)
+		}
+		if err != nil {
+			return fmt.Errorf("用户 %d: %v", userID, err)
+		}
+		results = append(results, result)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(results)
+}
+
+func migrateCommand(args []string) error {
+	if err := openDB(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	if len(args) > 0 && args[0] == "status" {
+		statuses, err := migrationStatus()
+		if err != nil {
+			return err
+		}
+		for _, status := range statuses {
+			appliedAt := "未执行"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Local().Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%3d  %-40s %s\n", status.Version, status.Name, appliedAt)
+		}
+		return nil
+	}
+	if len(args) > 0 {
+		return fmt.Errorf("未知参数 '%s'，用法: instago migrate [status]", args[0])
+	}
+
+	count, err := runMigrations()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("数据库迁移完成，本次执行 %d 个迁移\n", count)
+	return nil
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 跨域访问控制：只有允许列表中的来源可以从浏览器调用接口。
+// 前端页面由本服务提供，与接口同源，不需要加入列表。
+
+type CORSConfig struct {
+	AllowedOrigins   []string // 允许的来源，如 http://localhost:3000，"*" 允许任意来源
+	AllowedMethods   []string
+	AllowedHeaders   []string
+	AllowCredentials bool
+	MaxAge           int // 预检结果的缓存时间（秒）
+}
+
+// 按逗号拆分配置项，去掉空白和空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// 来源是否允许：与请求的 Host 相同的来源总是允许
+func (cfg CORSConfig) originAllowed(origin, host string) bool {
+	if origin == "http://"+host || origin == "https://"+host {
+		return true
+	}
+	for _, allowed := range cfg.AllowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimRight(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+func (cfg CORSConfig) methodAllowed(method string) bool {
+	for _, allowed := range cfg.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// 预检请求中的请求头都必须在允许列表中
+func (cfg CORSConfig) headersAllowed(requested string) bool {
+	for _, header := range splitList(requested) {
+		found := false
+		for _, allowed := range cfg.AllowedHeaders {
+			if strings.EqualFold(allowed, header) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// CORS 中间件。来源不在允许列表中的跨域请求直接拒绝，
+// 不只依赖浏览器拦截响应，避免简单请求在服务端产生副作用
+func corsMiddleware(cfg CORSConfig) gin.HandlerFunc {
+	allowMethods := strings.Join(cfg.AllowedMethods, ", ")
+	allowHeaders := strings.Join(cfg.AllowedHeaders, ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		c.Header("Vary", "Origin")
+
+		// 非浏览器客户端不带 Origin
+		if origin == "" {
+			if c.Request.Method == http.MethodOptions {
+				c.AbortWithStatus(204)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		if !cfg.originAllowed(origin, c.Request.Host) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Origin not allowed"})
+			return
+		}
+
+		// 回显具体来源而不是 *，携带凭据的请求不允许使用 *
+		c.Header("Access-Control-Allow-Origin", origin)
+		if cfg.AllowCredentials {
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+
+		// 预检请求
+		if c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != "" {
+			if !cfg.methodAllowed(c.GetHeader("Access-Control-Request-Method")) ||
+				!cfg.headersAllowed(c.GetHeader("Access-Control-Request-Headers")) {
+				c.AbortWithStatusJSON(403, gin.H{"error": "CORS request not allowed"})
+				return
+			}
+			c.Header("Access-Control-Allow-Methods", allowMethods)
+			c.Header("Access-Control-Allow-Headers", allowHeaders)
+			c.Header("Access-Control-Max-Age", strconv.Itoa(cfg.MaxAge))
+			c.AbortWithStatus(204)
+			return
+		}
+
+		c.Next()
+	}
+}
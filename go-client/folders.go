@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+)
+
+// 删除非空文件夹时如何处理其中的子文件夹和对象
+const (
+	FolderDeleteEmpty        = ""               // 只允许删除空文件夹
+	FolderDeleteCascade      = "cascade"        // 连同所有子文件夹和对象一起删除
+	FolderDeleteMoveToParent = "move_to_parent" // 子文件夹和对象移到父文件夹
+	FolderDeleteMoveTo       = "move_to"        // 子文件夹和对象移到指定文件夹
+)
+
+// 删除文件夹会影响到的文件夹和对象
+type FolderDeletePlan struct {
+	FolderID       int    `json:"folder_id"`
+	Mode           string `json:"mode"`
+	TargetFolderID *int   `json:"target_folder_id,omitempty"`
+	DeletedFolders []int  `json:"deleted_folders"`
+	MovedFolders   []int  `json:"moved_folders"`
+	DeletedObjects []int  `json:"deleted_objects"`
+	MovedObjects   []int  `json:"moved_objects"`
+
+	deleted []Object // 提交后需要清理向量文档和图片
+}
+
+// 计算删除文件夹的影响范围，不修改数据
+func planFolderDelete(userID, id int, mode string, target int) (FolderDeletePlan, error) {
+	plan := FolderDeletePlan{
+		FolderID:       id,
+		Mode:           mode,
+		DeletedFolders: []int{id},
+		MovedFolders:   []int{},
+		DeletedObjects: []int{},
+		MovedObjects:   []int{},
+	}
+
+	var folder Folder
+	err := db.QueryRow("SELECT id, name, upper FROM folders WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&folder.ID, &folder.Name, &folder.Upper)
+	if err == sql.ErrNoRows {
+		return plan, validationError("文件夹不存在")
+	}
+	if err != nil {
+		return plan, err
+	}
+	if folder.ID == folder.Upper {
+		return plan, validationError("不能删除根文件夹")
+	}
+	if folder.ID == config.InboxFolderID {
+		return plan, validationError("不能删除收件箱文件夹，请先修改 INBOX_FOLDER_ID")
+	}
+
+	subfolders, err := getSubFolders(userID, id)
+	if err != nil {
+		return plan, err
+	}
+	objects, err := findObjects(ObjectFilter{UserID: userID, FolderIDs: []int{id}})
+	if err != nil {
+		return plan, err
+	}
+
+	switch mode {
+	case FolderDeleteEmpty:
+		if len(subfolders) > 0 || len(objects) > 0 {
+			return plan, validationError("文件夹不为空，请指定删除方式：cascade、move_to_parent 或 move_to")
+		}
+		return plan, nil
+
+	case FolderDeleteCascade:
+		if plan.DeletedFolders, err = getDescendantFolderIDs(id); err != nil {
+			return plan, err
+		}
+		if err := checkInboxNotDeleted(plan.DeletedFolders); err != nil {
+			return plan, err
+		}
+		if plan.deleted, err = findObjects(ObjectFilter{UserID: userID, FolderIDs: plan.DeletedFolders}); err != nil {
+			return plan, err
+		}
+		for _, obj := range plan.deleted {
+			plan.DeletedObjects = append(plan.DeletedObjects, obj.ID)
+		}
+		return plan, nil
+
+	case FolderDeleteMoveToParent:
+		target = folder.Upper
+
+	case FolderDeleteMoveTo:
+		exists, err := folderExists(userID, target)
+		if err != nil {
+			return plan, err
+		}
+		if !exists {
+			return plan, validationError("目标文件夹不存在")
+		}
+		descendants, err := getDescendantFolderIDs(id)
+		if err != nil {
+			return plan, err
+		}
+		for _, descendant := range descendants {
+			if descendant == target {
+				return plan, validationError("不能移动到被删除的文件夹或其子文件夹中")
+			}
+		}
+
+	default:
+		return plan, validationError(fmt.Sprintf("无效的删除方式 '%s'", mode))
+	}
+
+	// 移动：直接子文件夹和对象挂到目标文件夹下，目标位置不能有同名文件夹
+	plan.TargetFolderID = &target
+	for _, sub := range subfolders {
+		var count int
+		err := db.QueryRow("SELECT COUNT(*) FROM folders WHERE name = ? AND upper = ? AND id != ?", sub.Name, target, id).Scan(&count)
+		if err != nil {
+			return plan, err
+		}
+		if count > 0 {
+			return plan, validationError(fmt.Sprintf("文件夹 '%s' 在目标位置已存在", sub.Name))
+		}
+		plan.MovedFolders = append(plan.MovedFolders, sub.ID)
+	}
+	for _, obj := range objects {
+		plan.MovedObjects = append(plan.MovedObjects, obj.ID)
+	}
+	return plan, nil
+}
+
+// 按计划删除文件夹
+//
+// 数据库中的修改在一个事务中完成；计划之后可能有新上传的对象或新建的子文件夹，
+// 因此要删除的文件夹和对象在事务内按文件夹重新查询，计划中的列表随之更新。
+// 向量文档和图片文件不在事务内，提交后再清理，
+// 清理失败时删除仍然算成功，返回警告；残留的向量文档会在搜索时被数据库校验过滤掉，
+// 也可以用 reindex 修复
+func executeFolderDelete(userID int, plan *FolderDeletePlan) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if plan.TargetFolderID != nil {
+		movedObjects, err := queryObjects(tx, ObjectFilter{UserID: userID, FolderIDs: []int{plan.FolderID}})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE folders SET upper = ? WHERE upper = ? AND id != upper", *plan.TargetFolderID, plan.FolderID); err != nil {
+			return nil, err
+		}
+		if _, err := tx.Exec("UPDATE objects SET folder_id = ? WHERE folder_id = ?", *plan.TargetFolderID, plan.FolderID); err != nil {
+			return nil, err
+		}
+		plan.MovedObjects = []int{}
+		for _, obj := range movedObjects {
+			plan.MovedObjects = append(plan.MovedObjects, obj.ID)
+		}
+	}
+
+	// 子文件夹已移走时只剩文件夹本身
+	if plan.DeletedFolders, err = queryDescendantFolderIDs(tx, plan.FolderID); err != nil {
+		return nil, err
+	}
+	if err := checkInboxNotDeleted(plan.DeletedFolders); err != nil {
+		return nil, err
+	}
+	if plan.deleted, err = queryObjects(tx, ObjectFilter{UserID: userID, FolderIDs: plan.DeletedFolders}); err != nil {
+		return nil, err
+	}
+	if plan.Mode == FolderDeleteEmpty && (len(plan.DeletedFolders) > 1 || len(plan.deleted) > 0) {
+		return nil, validationError("文件夹不为空，请指定删除方式：cascade、move_to_parent 或 move_to")
+	}
+	plan.DeletedObjects = []int{}
+	for _, obj := range plan.deleted {
+		plan.DeletedObjects = append(plan.DeletedObjects, obj.ID)
+	}
+
+	folderArgs := intArgs(plan.DeletedFolders)
+	if ftsEnabled {
+		if _, err := tx.Exec("DELETE FROM objects_fts WHERE rowid IN (SELECT id FROM objects WHERE folder_id IN ("+placeholders(len(folderArgs))+"))", folderArgs...); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := tx.Exec("DELETE FROM objects WHERE folder_id IN ("+placeholders(len(folderArgs))+")", folderArgs...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM folders WHERE id IN ("+placeholders(len(folderArgs))+")", folderArgs...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM folder_rules WHERE folder_id IN ("+placeholders(len(folderArgs))+")", folderArgs...); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	ctx := context.Background()
+	var warnings []string
+	for _, obj := range plan.deleted {
+		if err := deleteObjectVectors(ctx, obj.UserID, obj.ID); err != nil {
+			warnings = append(warnings, fmt.Sprintf("对象 %d 的向量文档清理失败: %v", obj.ID, err))
+		}
+		if err := releaseBlob(obj.BlobHash); err != nil {
+			warnings = append(warnings, fmt.Sprintf("对象 %d 的图片清理失败: %v", obj.ID, err))
+		}
+	}
+	for _, warning := range warnings {
+		slog.Warn("文件夹已删除，清理失败", "folder_id", plan.FolderID, "warning", warning)
+	}
+	return warnings, nil
+}
+
+// 删除文件夹，返回提交后清理失败的警告
+func deleteFolder(userID, id int, mode string, target int) (FolderDeletePlan, []string, error) {
+	plan, err := planFolderDelete(userID, id, mode, target)
+	if err != nil {
+		return plan, nil, err
+	}
+	warnings, err := executeFolderDelete(userID, &plan)
+	return plan, warnings, err
+}
+
+// 收件箱文件夹在启动时校验，删除后服务无法启动
+func checkInboxNotDeleted(folderIDs []int) error {
+	for _, id := range folderIDs {
+		if id == config.InboxFolderID {
+			return validationError("不能删除收件箱文件夹或其上级文件夹，请先修改 INBOX_FOLDER_ID")
+		}
+	}
+	return nil
+}
+
+func intArgs(ids []int) []interface{} {
+	args := make([]interface{}, len(ids))
+	for i, id := range ids {
+		args[i] = id
+	}
+	return args
+}
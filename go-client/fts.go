@@ -0,0 +1,156 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"strings"
+	"unicode/utf8"
+)
+
+// 关键词检索：FTS5 全文索引 objects_fts，rowid 与 objects.id 一致
+//
+// 使用 trigram 分词，中文无需额外分词器；少于3个字符的词 trigram 无法匹配，退化为 LIKE。
+// FTS5 需要以 -tags sqlite_fts5 编译，不可用时整个关键词检索退化为对 objects 的 LIKE 查询。
+
+// 是否可以使用 FTS5
+var ftsEnabled bool
+
+// trigram 分词器能匹配的最短长度
+const ftsMinTermLength = 3
+
+// 创建全文索引表，并为旧数据补建索引
+func initFTS() error {
+	// 表可能由启用了 FTS5 的版本创建，所以先检查编译选项而不是依赖建表是否报错
+	var enabled bool
+	if err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&enabled); err != nil {
+		return err
+	}
+	if !enabled {
+		slog.Warn("SQLite 未启用 FTS5（需 -tags sqlite_fts5 编译），关键词搜索退化为 LIKE 查询")
+		return nil
+	}
+
+	_, err := db.Exec(`
+	CREATE VIRTUAL TABLE IF NOT EXISTS objects_fts USING fts5(
+		name, description, digest, keywords,
+		tokenize = 'trigram'
+	);
+	`)
+	if err != nil {
+		return err
+	}
+	ftsEnabled = true
+
+	// 为还没有索引的对象补建索引
+	result, err := db.Exec(`
+	INSERT INTO objects_fts (rowid, name, description, digest, keywords)
+	SELECT id, name, description, digest, keywords FROM objects
+	WHERE id NOT IN (SELECT rowid FROM objects_fts)`)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n > 0 {
+		slog.Info("已建立全文索引", "objects", n)
+	}
+	return nil
+}
+
+// 写入或更新对象的全文索引
+func indexObjectText(obj Object) error {
+	if !ftsEnabled {
+		return nil
+	}
+	_, err := db.Exec("INSERT OR REPLACE INTO objects_fts (rowid, name, description, digest, keywords) VALUES (?, ?, ?, ?, ?)",
+		obj.ID, obj.Name, obj.Description, obj.Digest, obj.Keywords)
+	return err
+}
+
+// 关键词检索，返回按相关度排序的对象ID
+func keywordSearch(query string, limit int, filter ObjectFilter) ([]int, error) {
+	terms := strings.Fields(query)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	where, filterArgs := filter.where()
+
+	var rows *sql.Rows
+	var err error
+	if matchQuery := ftsMatchQuery(terms); ftsEnabled && matchQuery != "" {
+		// 按 BM25 排序，分数越小越相关
+		args := append([]interface{}{matchQuery}, filterArgs...)
+		args = append(args, limit)
+		rows, err = db.Query(`
+		SELECT o.id FROM objects_fts
+		JOIN objects o ON o.id = objects_fts.rowid
+		WHERE objects_fts MATCH ?`+where+`
+		ORDER BY bm25(objects_fts) LIMIT ?`, args...)
+	} else {
+		rows, err = likeSearch(terms, limit, where, filterArgs)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// 生成 FTS5 MATCH 表达式：每个词作为短语匹配，任意一个命中即可，由 BM25 决定排序
+// 过短的词 trigram 无法匹配；只要有一个词过短就返回空串，交给 LIKE 处理，避免漏掉结果
+func ftsMatchQuery(terms []string) string {
+	phrases := make([]string, 0, len(terms))
+	for _, term := range terms {
+		if utf8.RuneCountInString(term) < ftsMinTermLength {
+			return ""
+		}
+		phrases = append(phrases, `"`+strings.ReplaceAll(term, `"`, `""`)+`"`)
+	}
+	return strings.Join(phrases, " OR ")
+}
+
+// LIKE 检索：按命中的词数排序
+func likeSearch(terms []string, limit int, where string, filterArgs []interface{}) (*sql.Rows, error) {
+	from := "objects o"
+	columns := []string{"o.name", "o.description", "o.digest", "o.keywords"}
+
+	var conds []string
+	var args []interface{}
+	for _, term := range terms {
+		pattern := "%" + escapeLike(term) + "%"
+		var ors []string
+		for _, col := range columns {
+			ors = append(ors, col+` LIKE ? ESCAPE '\'`)
+			args = append(args, pattern)
+		}
+		conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+	}
+
+	// 命中的词数作为排序依据，SQLite 中布尔表达式的值为 0/1
+	score := strings.Join(conds, " + ")
+	query := "SELECT o.id FROM " + from + " WHERE (" + strings.Join(conds, " OR ") + ")" + where +
+		" ORDER BY (" + score + ") DESC, o.id DESC LIMIT ?"
+
+	allArgs := append([]interface{}{}, args...)
+	allArgs = append(allArgs, filterArgs...)
+	allArgs = append(allArgs, args...)
+	allArgs = append(allArgs, limit)
+	return db.Query(query, allArgs...)
+}
+
+// 删除对象的全文索引
+func deleteObjectText(objectID int) error {
+	if !ftsEnabled {
+		return nil
+	}
+	_, err := db.Exec("DELETE FROM objects_fts WHERE rowid = ?", objectID)
+	return err
+}
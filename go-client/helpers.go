@@ -1,27 +1,20 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
-	"errors"
 	"fmt"
-	"io"
-	"net/http"
+	"log/slog"
+	"math"
 	"strconv"
 	"strings"
-	"time"
 
 	chromem "github.com/philippgille/chromem-go"
 )
 
-// 千问视觉模型分析图片
-func analyzeImageWithQwenVL(image UploadRequest) (string, error) {
-	if config.QwenVLAPIKey == "" {
-		return "Mock description: This is a sample image description for testing purposes.", nil
-	}
-
-	// 构建请求体 - 修复千问VL API格式
+// 调用视觉模型分析图片
+func analyzeImage(ctx context.Context, image UploadRequest) (string, error) {
 	prompt := "您擅长分析截图内容并基于其内容自动化任务，最终向用户输出有用的信息。\n"
 
 	if image.ScreenshotTimestamp > 0 {
@@ -38,146 +31,197 @@ func analyzeImageWithQwenVL(image UploadRequest) (string, error) {
 	prompt += "请详细描述这张截图的内容，包括文本、界面元素、操作步骤等所有可见信息。\n" +
 		"你应该优先设置描述的属性：截图时间戳、来源应用、标签 \n" +
 		"你应该在描述的最后一部分给出一段具有特定标识的原文内容（约15-20字），并分析这份图片可能来自哪个站点。输出格式：'可能来自的站点':'推特、微博、小红书','原文内容':'15-20字的能够找到原文的原文内容。'\n"
-	requestBody := map[string]interface{}{
-		"model": "qwen-vl-plus",
-		"input": map[string]interface{}{
-			"messages": []map[string]interface{}{
-				{
-					"role": "user",
-					"content": []map[string]interface{}{
-						{
-							"image": fmt.Sprintf("data:image/jpeg;base64,%s", image.ScreenshotFileBlob),
-						},
-						{
-							"text": prompt,
-						},
-					},
-				},
-			},
-		},
-	}
-
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		fmt.Printf("JSON Marshal error: %v\n", err)
-		return "", err
-	}
-
-	fmt.Printf("Calling Qwen VL API with key: %s...\n", config.QwenVLAPIKey[:10])
-
-	req, err := http.NewRequest("POST", "https://dashscope.aliyuncs.com/api/v1/services/aigc/multimodal-generation/generation", bytes.NewBuffer(jsonData))
-	if err != nil {
-		fmt.Printf("HTTP Request creation error: %v\n", err)
-		return "", err
-	}
-
-	req.Header.Set("Authorization", "Bearer "+config.QwenVLAPIKey)
-	req.Header.Set("Content-Type", "application/json")
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("HTTP Request error: %v\n", err)
-		return "", err
-	}
-	defer resp.Body.Close()
 
-	fmt.Printf("API Response Status: %s\n", resp.Status)
+	return visionModel.DescribeImage(ctx, prompt, image.ScreenshotFileBlob)
+}
 
-	body, err := io.ReadAll(resp.Body)
+// 获取用户的文件夹树，供文本模型选择文件夹
+func getFolderTree(userID int) (string, error) {
+	root, err := buildFolderTree(userID)
 	if err != nil {
-		fmt.Printf("Response body read error: %v\n", err)
 		return "", err
 	}
 
-	fmt.Printf("API Response Body: %s\n", string(body))
+	// 构建树形结构的字符串表示
+	var builder strings.Builder
+	builder.WriteString("文件夹结构:\n")
+	writeFolderNode(&builder, root, 0)
 
-	var response QwenVLResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		fmt.Printf("JSON Unmarshal error: %v\n", err)
-		return "", err
-	}
+	return builder.String(), nil
+}
 
-	if len(response.Output.Choices) == 0 {
-		fmt.Printf("No choices in response: %+v\n", response)
-		return "", fmt.Errorf("no response from Qwen VL model")
+func writeFolderNode(builder *strings.Builder, node *FolderNode, depth int) {
+	indent := strings.Repeat("  ", depth)
+	builder.WriteString(fmt.Sprintf("%s- %s (ID: %d)", indent, node.Name, node.ID))
+	if node.Description != "" {
+		builder.WriteString("：" + node.Description)
 	}
-
-	// 提取文本内容
-	content := response.Output.Choices[0].Message.Content
-	if len(content) == 0 {
-		return "", fmt.Errorf("no content in response")
+	builder.WriteString("\n")
+	for _, child := range node.Children {
+		writeFolderNode(builder, child, depth+1)
 	}
+}
 
-	return content[0].Text, nil
+// 文件夹树节点
+type FolderNode struct {
+	ID               int           `json:"id"`
+	Name             string        `json:"name"`
+	Upper            int           `json:"upper"`
+	Description      string        `json:"description"`
+	ObjectCount      int           `json:"object_count"`       // 直接包含的对象数量
+	TotalObjectCount int           `json:"total_object_count"` // 包含所有子文件夹的对象数量
+	Children         []*FolderNode `json:"children"`
 }
 
-// 获取文件夹树
-func getFolderTree() (string, error) {
-	rows, err := db.Query("SELECT id, name, upper FROM folders ORDER BY upper, id")
+// 构建完整的文件夹树，返回根节点
+//
+// 父文件夹不存在或处于环中的文件夹挂到根节点下，保证每个文件夹只出现一次
+func buildFolderTree(userID int) (*FolderNode, error) {
+	rows, err := db.Query("SELECT id, name, upper, description FROM folders WHERE user_id = ? ORDER BY id", userID)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer rows.Close()
 
 	var folders []Folder
 	for rows.Next() {
 		var folder Folder
-		if err := rows.Scan(&folder.ID, &folder.Name, &folder.Upper); err != nil {
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.Upper, &folder.Description); err != nil {
 			continue
 		}
 		folders = append(folders, folder)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
-	// 构建树形结构的字符串表示
-	var builder strings.Builder
-	builder.WriteString("文件夹结构:\n")
-	for _, folder := range folders {
-		indent := strings.Repeat("  ", getDepth(folder.ID, folders))
-		builder.WriteString(fmt.Sprintf("%s- %s (ID: %d)\n", indent, folder.Name, folder.ID))
+	counts, err := getFolderObjectCounts(userID)
+	if err != nil {
+		return nil, err
 	}
 
-	return builder.String(), nil
+	children := make(map[int][]Folder)
+	var root *Folder
+	for i, folder := range folders {
+		if folder.ID == folder.Upper {
+			root = &folders[i]
+			continue
+		}
+		children[folder.Upper] = append(children[folder.Upper], folder)
+	}
+	if root == nil {
+		root = &Folder{ID: 0, Name: "Root", Upper: 0}
+	}
+
+	visited := map[int]bool{root.ID: true}
+	var visit func(folder Folder) *FolderNode
+	visit = func(folder Folder) *FolderNode {
+		node := &FolderNode{
+			ID:          folder.ID,
+			Name:        folder.Name,
+			Upper:       folder.Upper,
+			Description: folder.Description,
+			ObjectCount: counts[folder.ID],
+			Children:    []*FolderNode{},
+		}
+		node.TotalObjectCount = node.ObjectCount
+		for _, child := range children[folder.ID] {
+			if visited[child.ID] {
+				continue
+			}
+			visited[child.ID] = true
+			childNode := visit(child)
+			node.Children = append(node.Children, childNode)
+			node.TotalObjectCount += childNode.TotalObjectCount
+		}
+		return node
+	}
+
+	rootNode := visit(*root)
+	for _, folder := range folders {
+		if visited[folder.ID] {
+			continue
+		}
+		visited[folder.ID] = true
+		node := visit(folder)
+		rootNode.Children = append(rootNode.Children, node)
+		rootNode.TotalObjectCount += node.TotalObjectCount
+	}
+	return rootNode, nil
 }
 
-// 计算文件夹深度
-func getDepth(folderID int, folders []Folder) int {
-	if folderID == 0 {
-		return 0
+// 每个文件夹直接包含的对象数量
+func getFolderObjectCounts(userID int) (map[int]int, error) {
+	rows, err := db.Query("SELECT folder_id, COUNT(*) FROM objects WHERE user_id = ? GROUP BY folder_id", userID)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	for _, folder := range folders {
-		if folder.ID == folderID {
-			return 1 + getDepth(folder.Upper, folders)
+	counts := make(map[int]int)
+	for rows.Next() {
+		var folderID, count int
+		if err := rows.Scan(&folderID, &count); err != nil {
+			return nil, err
 		}
+		counts[folderID] = count
 	}
-	return 0
+	return counts, rows.Err()
 }
 
 // 搜索内容结构体
 type SearchContent struct {
 	Name          string
 	Digest        string
-	FolderID      int
+	FolderID      int // 最终存放的文件夹，由 resolveUploadFolder 确定
 	Keywords      string
 	Questions     []string
 	Scenario      string
 	FromSite      string
 	OriginContent string
+
+	SuggestedFolder string  // 模型返回的原始 folder_id，未经校验
+	Confidence      float64 // 模型对推荐文件夹的置信度
 }
 
-// 使用千问文本模型处理描述，生成多维度搜索内容
-func processWithQwenText(description, folderTree string) (SearchContent, error) {
-	if config.QwenTextAPIKey == "" {
-		return SearchContent{}, errors.New("QwenText API key is not set")
+// 对象文件夹的来源
+const (
+	FolderSourceRule  = "rule"  // 命中分类规则
+	FolderSourceModel = "model" // 采用文本模型的推荐
+	FolderSourceInbox = "inbox" // 模型推荐无效，放入收件箱文件夹
+)
+
+// 确定上传对象的文件夹：分类规则优先，其次是存在的模型推荐，都不可用时放入收件箱
+func resolveUploadFolder(ctx context.Context, userID int, rule *FolderRule, sc SearchContent) (int, string, error) {
+	if rule != nil {
+		return rule.FolderID, FolderSourceRule, nil
+	}
+	if folderID, ok := parseFolderID(json.RawMessage(sc.SuggestedFolder)); ok {
+		exists, err := folderExists(userID, folderID)
+		if err != nil {
+			return 0, "", err
+		}
+		if exists {
+			return folderID, FolderSourceModel, nil
+		}
+	}
+	inbox, err := userInboxFolder(userID)
+	if err != nil {
+		return 0, "", err
 	}
+	slog.WarnContext(ctx, "模型推荐的文件夹无效，放入收件箱文件夹", "suggested_folder", sc.SuggestedFolder, "folder_id", inbox)
+	return inbox, FolderSourceInbox, nil
+}
 
+// 使用文本模型处理描述，生成多维度搜索内容
+func processWithTextModel(ctx context.Context, description, folderTree string) (SearchContent, error) {
 	prompt := fmt.Sprintf(`
 根据以下图片描述和文件夹结构，请：
 1. 生成一个简洁的文件标题（不超过20字，适合作为文件名）
 2. 生成一个简洁的摘要(大约在150字，需要包含图片描述的关键属性比如精确到小时的毫秒级时间戳、来源应用、标签）
 3. 推荐最合适的存储文件夹ID：注意：你应该详细分析文件夹结构，比如一个父级文件夹可能指向“品牌”，“类别”，子文件夹是更具体的信息，一个可能的场景比如：
  "算法\n\t力扣\n\t洛谷"，这个说明在算法文件夹下存在着力扣、洛谷两个子文件夹，如果一个截图内容中包含“力扣”、“leetcode”字样，你应该将其放在力扣文件夹下。
+文件夹名后“：”之后的内容是用户写的文件夹说明，优先按说明判断文件夹的用途。
 4. 生成用户可能搜索的关键词（5-10个，用逗号分隔，需要有精确到小时的毫秒级时间戳）
 5. 生成用户可能会为了找到这张图片描述的语义化信息（3-4个)。
 6. 生成场景描述（简短描述这是什么场景/情况）。
@@ -196,6 +240,7 @@ func processWithQwenText(description, folderTree string) (SearchContent, error)
   "name": "文件标题",
   "digest": "摘要内容",
   "folder_id": 推荐的文件夹ID,
+  "confidence": 对推荐文件夹的置信度（0到1之间）,
   "keywords": "关键词1,关键词2,关键词3",
   "questions": ["用户可能会为了找到这张图片描述的语义化信息1", "用户可能会为了找到这张图片描述的语义化信息2","用户可能会为了找到这张图片描述的语义化信息3"],
   "scenario": "场景描述",
@@ -204,310 +249,237 @@ func processWithQwenText(description, folderTree string) (SearchContent, error)
 }
 `, description, folderTree)
 
-	requestBody := map[string]interface{}{
-		"model": "qwen-turbo",
-		"input": map[string]interface{}{
-			"messages": []map[string]interface{}{
-				{
-					"role":    "user",
-					"content": prompt,
-				},
-			},
-		},
+	var searchContent SearchContent
+	err := generateStructured(ctx, prompt, func(content string) error {
+		var err error
+		searchContent, err = parseSearchContent(content)
+		return err
+	})
+	return searchContent, err
+}
+
+// 解析模型返回的folder_id，可能是整数或数字字符串；小数、空值等无法解析时返回 false
+func parseFolderID(raw json.RawMessage) (int, bool) {
+	var number float64
+	if err := json.Unmarshal(raw, &number); err == nil {
+		if number != math.Trunc(number) {
+			return 0, false
+		}
+		return int(number), true
 	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		if parsed, err := strconv.Atoi(strings.TrimSpace(text)); err == nil {
+			return parsed, true
+		}
+	}
+	return 0, false
+}
 
-	jsonData, err := json.Marshal(requestBody)
+// 创建对象
+func createObject(obj Object) (int, error) {
+	result, err := db.Exec(`INSERT INTO objects (user_id, name, data, blob_hash, blob_size, mime_type, description, folder_id, possible_from,
+		screenshot_timestamp, screenshot_app_name, screenshot_tags, created_at,
+		suggested_folder_id, folder_confidence, folder_source, index_state,
+		digest, keywords, questions, scenario, from_site, origin_content)
+		VALUES (?, ?, '', ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		obj.UserID, obj.Name, obj.BlobHash, obj.BlobSize, obj.MimeType, obj.Description, obj.FolderID, obj.PossibleFrom,
+		obj.ScreenshotTimestamp, obj.ScreenshotAppName, normalizeTags(obj.ScreenshotTags),
+		obj.SuggestedFolderID, obj.FolderConfidence, obj.FolderSource, IndexPending,
+		obj.Digest, obj.Keywords, questionsJSON(obj.Questions), obj.Scenario, obj.FromSite, obj.OriginContent)
 	if err != nil {
-		return SearchContent{}, err
+		return 0, err
 	}
 
-	req, err := http.NewRequest("POST", "https://dashscope.aliyuncs.com/api/v1/services/aigc/text-generation/generation", bytes.NewBuffer(jsonData))
+	id, err := result.LastInsertId()
 	if err != nil {
-		return SearchContent{}, err
+		return 0, err
 	}
 
-	req.Header.Set("Authorization", "Bearer "+config.QwenTextAPIKey)
-	req.Header.Set("Content-Type", "application/json")
+	return int(id), nil
+}
 
-	fmt.Printf("Calling Qwen Text API with key: %s...\n", config.QwenTextAPIKey[:10])
+// 规范化标签：按中英文逗号拆分并去掉空白，统一用逗号连接
+func normalizeTags(tags string) string {
+	return strings.Join(splitTags(tags), ",")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("HTTP Request error: %v\n", err)
-		return SearchContent{}, err
+func splitTags(tags string) []string {
+	var result []string
+	for _, tag := range strings.FieldsFunc(tags, func(r rune) bool { return r == ',' || r == '，' }) {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			result = append(result, tag)
+		}
 	}
-	defer resp.Body.Close()
-
-	fmt.Printf("Text API Response Status: %s\n", resp.Status)
+	return result
+}
 
-	body, err := io.ReadAll(resp.Body)
+// 存储到向量数据库（多维度内容）
+func storeInVectorDB(obj Object) error {
+	ctx := context.Background()
+	collection, err := userCollection(obj.UserID)
 	if err != nil {
-		fmt.Printf("Response body read error: %v\n", err)
-		return SearchContent{}, err
+		return err
 	}
-
-	fmt.Printf("Text API Response Body: %s\n", string(body))
-
-	var response QwenTextResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		fmt.Printf("JSON Unmarshal error: %v\n", err)
-		return SearchContent{}, err
+	for _, doc := range buildVectorDocs(obj) {
+		if err := collection.AddDocument(ctx, doc); err != nil {
+			return err
+		}
 	}
+	return nil
+}
 
-	if response.Output.Text == "" {
-		fmt.Printf("No text in response: %+v\n", response)
-		return SearchContent{}, fmt.Errorf("no response from Qwen text model")
-	}
+// 生成对象的向量文档：综合内容、关键词和每个问题各一个文档。
+// 没有保存摘要的旧对象用描述代替摘要，上传、重试和重建都生成相同的内容
+func buildVectorDocs(obj Object) []chromem.Document {
+	objectID := obj.ID
 
-	// 解析JSON响应 - 处理folder_id可能为字符串的情况
-	var result struct {
-		Name          string      `json:"name"`
-		Digest        string      `json:"digest"`
-		FolderID      interface{} `json:"folder_id"`
-		Keywords      string      `json:"keywords"`
-		Questions     []string    `json:"questions"`
-		Scenario      string      `json:"scenario"`
-		FromSite      string      `json:"from_site"`
-		OriginContent string      `json:"origin_content"` // 很少的一部分文本
+	digest := obj.Digest
+	if digest == "" {
+		digest = obj.Description
 	}
 
-	content := response.Output.Text
-	if err = json.Unmarshal([]byte(content), &result); err != nil {
-		// 如果解析失败，使用默认值
-		return SearchContent{}, err
-	}
+	// 构建综合搜索内容，包含所有维度
+	combinedContent := fmt.Sprintf("%s\n关键词: %s\n场景: %s",
+		digest,
+		obj.Keywords,
+		obj.Scenario)
 
-	// 处理folder_id类型转换
-	var folderID int
-	switch v := result.FolderID.(type) {
-	case float64:
-		folderID = int(v)
-	case string:
-		if parsed, parseErr := strconv.Atoi(v); parseErr == nil {
-			folderID = parsed
-		} else {
-			folderID = 0 // 默认根文件夹
-		}
-	case int:
-		folderID = v
-	default:
-		folderID = 0 // 默认根文件夹
-	}
-
-	return SearchContent{
-		Name:          result.Name,
-		Digest:        result.Digest,
-		FolderID:      folderID,
-		Keywords:      result.Keywords,
-		Questions:     result.Questions,
-		Scenario:      result.Scenario,
-		FromSite:      result.FromSite,
-		OriginContent: result.OriginContent,
-	}, nil
-}
-
-func standardizeQueryWithOllama(userQuery string) (string, error) {
-	// todo: 太他妈傻逼了
-	//// 获取当前时间信息
-	//currentTime := time.Now().Truncate(time.Hour)
-	//
-	//currentTimestamp := currentTime.UnixMilli()
-	//currentDate := currentTime.Format("2006年01月02日 15:04")
-	//weekday := currentTime.Weekday()
-	//weekdayMap := map[time.Weekday]string{
-	//	time.Sunday: "周日", time.Monday: "周一", time.Tuesday: "周二",
-	//	time.Wednesday: "周三", time.Thursday: "周四", time.Friday: "周五", time.Saturday: "周六",
-	//}
-	//yesterdayTime14oclock := currentTime.AddDate(0, 0, -1).Truncate(24 * time.Hour).Add(14 * time.Hour).UnixMilli()
-	//daybeforeyestday := currentTime.AddDate(0, 0, -2).UnixMilli()
-	//	prompt := fmt.Sprintf(`你是一个查询标准化助手。用户会输入搜索查询，你需要：
-	//
-	//1. 如果查询中包含时间概念（如"昨天"、"三天前"、"上周"、"今天下午"等），请计算出对应的精确到小时的毫秒级时间戳，小时后面的位全部置0
-	//2. 保留原始查询的核心内容
-	//3. 返回标准化后的查询语句
-	//
-	//当前时间信息：
-	//- 当前时间：%s (%s)
-	//- 当前时间戳（毫秒）：%d
-	//- 时间戳计算方式：将目标时间精确到小时，即分钟和秒都设为0，然后转换为毫秒时间戳
-	//
-	//示例：
-	//- 用户查询："昨天下午的截图"
-	//- 标准化查询："昨天下午的截图 时间戳:%d"（假设昨天下午2点对应的毫秒时间戳）
-	//
-	//- 用户查询："三天前看到的代码"
-	//- 标准化查询："三天前看到的代码 时间戳:%d"（假设三天前对应的毫秒时间戳）
-	//
-	//用户查询："%s"
-	//
-	//请返回标准化后的查询语句：`, currentDate, weekdayMap[weekday], currentTimestamp, yesterdayTime14oclock, daybeforeyestday, userQuery)
-	prompt := fmt.Sprintf(`注意：不要参考历史上下文。\n
-你是一个查询标准化助手。用户会输入搜索查询，你需要返回标准化后的查询语句，\n
-
-注意：\n
-1. 你不能解释某个关键的词语，因为我需要根据关键词进行搜索。\n
-2. 语句本身没有对话的含义，你只需要去除其中的不重要的信息。\n
-3. 尝试多个维度去描述这个查询。\n
-用户描述的内容是："%s"\n
-
-请返回你标准化处理后的查询语句，不要携带其他内容，只返回json，按照此格式返回：\n
-{
-	"response": ""
-}\n
-参考示例：\n
-用户查询：我做的一个链表的题。\n
-返回：{
-	"response": "链表，算法题"
-}\n
-用户查询：一篇有关中医、医药的文章。\n
-返回：{
-	"response": "中医、医药、文章"
-}\n
-`, userQuery)
-
-	requestBody := map[string]interface{}{
-		"model":  "qwen2:0.5b",
-		"prompt": prompt,
-		"stream": false,
-	}
-	jsonData, err := json.Marshal(requestBody)
-	if err != nil {
-		return userQuery, err // 如果出错，返回原查询
-	}
-	req, err := http.NewRequest("POST", "http://localhost:11434/api/generate", bytes.NewBuffer(jsonData))
-	if err != nil {
-		return userQuery, err
+	// 添加问题内容
+	for _, question := range obj.Questions {
+		combinedContent += "\n问题: " + question
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("Ollama请求失败，使用原查询: %v\n", err)
-		return userQuery, nil // 如果Ollama不可用，返回原查询
-	}
-	defer resp.Body.Close()
+	// 主文档：综合内容
+	docs := []chromem.Document{{
+		ID:       strconv.Itoa(objectID),
+		Content:  combinedContent,
+		Metadata: vectorMetadata(obj, "main"),
+	}}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return userQuery, err
+	// 额外存储：关键词文档（提高关键词匹配权重）
+	if obj.Keywords != "" {
+		docs = append(docs, chromem.Document{
+			ID:       strconv.Itoa(objectID) + "_keywords",
+			Content:  obj.Keywords,
+			Metadata: vectorMetadata(obj, "keywords"),
+		})
 	}
 
-	var response struct {
-		Response string `json:"response"`
+	// 额外存储：问题文档（提高问题匹配权重）
+	for i, question := range obj.Questions {
+		docs = append(docs, chromem.Document{
+			ID:       fmt.Sprintf("%d_question_%d", objectID, i),
+			Content:  question,
+			Metadata: vectorMetadata(obj, "question"),
+		})
 	}
 
-	if err := json.Unmarshal(body, &response); err != nil {
-		return userQuery, err
-	}
+	return docs
+}
 
-	standardizedQuery := strings.TrimSpace(response.Response)
-	if standardizedQuery == "" {
-		return userQuery, nil
-	}
+// objects表查询的公共列，与 scanObject 一一对应
+const objectColumns = `id, user_id, name, blob_hash, blob_size, mime_type, description, folder_id, possible_from,
+	screenshot_timestamp, screenshot_app_name, screenshot_tags, created_at,
+	suggested_folder_id, folder_confidence, folder_source,
+	index_state, index_error, index_attempts,
+	digest, keywords, questions, scenario, from_site, origin_content`
 
-	fmt.Printf("查询标准化: '%s' -> '%s'\n", userQuery, standardizedQuery)
-	return standardizedQuery, nil
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
 
+// *sql.DB 和 *sql.Tx 共有的查询方法，需要在事务内复用的查询接收它
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 }
 
-// 创建对象
-func createObject(name, imageData, description string, folderID int, possibleFrom string) (int, error) {
-	result, err := db.Exec("INSERT INTO objects (name, data, description, folder_id,possible_from) VALUES (?, ?, ?, ?,?)",
-		name, imageData, description, folderID, possibleFrom)
+func scanObject(row rowScanner) (Object, error) {
+	var obj Object
+	var possibleFrom sql.NullString
+	var createdAt sql.NullTime
+	var questions string
+	err := row.Scan(&obj.ID, &obj.UserID, &obj.Name, &obj.BlobHash, &obj.BlobSize, &obj.MimeType, &obj.Description, &obj.FolderID, &possibleFrom,
+		&obj.ScreenshotTimestamp, &obj.ScreenshotAppName, &obj.ScreenshotTags, &createdAt,
+		&obj.SuggestedFolderID, &obj.FolderConfidence, &obj.FolderSource,
+		&obj.IndexState, &obj.IndexError, &obj.IndexAttempts,
+		&obj.Digest, &obj.Keywords, &questions, &obj.Scenario, &obj.FromSite, &obj.OriginContent)
 	if err != nil {
-		return 0, err
+		return obj, err
 	}
-
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
+	if err := json.Unmarshal([]byte(questions), &obj.Questions); err != nil || obj.Questions == nil {
+		obj.Questions = []string{}
 	}
-
-	return int(id), nil
+	obj.PossibleFrom = possibleFrom.String
+	obj.CreatedAt = createdAt.Time
+	return obj, err
 }
 
-// 存储到向量数据库（多维度内容）
-func storeInVectorDB(objectID int, searchContent SearchContent) error {
-	ctx := context.Background()
-
-	// 构建综合搜索内容，包含所有维度
-	combinedContent := fmt.Sprintf("%s\n关键词: %s\n场景: %s",
-		searchContent.Digest,
-		searchContent.Keywords,
-		searchContent.Scenario)
+// 根据ID获取对象
+func getObjectByID(id int) (Object, error) {
+	return scanObject(db.QueryRow("SELECT "+objectColumns+" FROM objects WHERE id = ?", id))
+}
 
-	// 添加问题内容
-	for _, question := range searchContent.Questions {
-		combinedContent += "\n问题: " + question
-	}
+// 更新对象的名称和所在文件夹
+func updateObject(obj Object) error {
+	_, err := db.Exec(`UPDATE objects SET name = ?, folder_id = ?, digest = ?, keywords = ?, questions = ?, scenario = ?
+		WHERE id = ?`, obj.Name, obj.FolderID, obj.Digest, obj.Keywords, questionsJSON(obj.Questions), obj.Scenario, obj.ID)
+	return err
+}
 
-	// 主文档：综合内容
-	mainDoc := chromem.Document{
-		ID:      strconv.Itoa(objectID),
-		Content: combinedContent,
+// 问题列表以JSON数组保存
+func questionsJSON(questions []string) string {
+	if questions == nil {
+		questions = []string{}
 	}
+	data, _ := json.Marshal(questions)
+	return string(data)
+}
 
-	if err := collection.AddDocument(ctx, mainDoc); err != nil {
+// 删除对象，同时清理全文索引、向量文档和不再被引用的图片
+func deleteObject(obj Object) error {
+	if _, err := db.Exec("DELETE FROM objects WHERE id = ?", obj.ID); err != nil {
 		return err
 	}
-
-	// 额外存储：关键词文档（提高关键词匹配权重）
-	keywordDoc := chromem.Document{
-		ID:      strconv.Itoa(objectID) + "_keywords",
-		Content: searchContent.Keywords,
-		Metadata: map[string]string{
-			"type":      "keywords",
-			"object_id": strconv.Itoa(objectID),
-		},
-	}
-
-	if err := collection.AddDocument(ctx, keywordDoc); err != nil {
+	if err := deleteObjectText(obj.ID); err != nil {
 		return err
 	}
-
-	// 额外存储：问题文档（提高问题匹配权重）
-	for i, question := range searchContent.Questions {
-		questionDoc := chromem.Document{
-			ID:      fmt.Sprintf("%d_question_%d", objectID, i),
-			Content: question,
-			Metadata: map[string]string{
-				"type":      "question",
-				"object_id": strconv.Itoa(objectID),
-			},
-		}
-
-		if err := collection.AddDocument(ctx, questionDoc); err != nil {
-			return err
-		}
+	if err := deleteObjectVectors(context.Background(), obj.UserID, obj.ID); err != nil {
+		return err
 	}
-
-	return nil
+	return releaseBlob(obj.BlobHash)
 }
 
-// 根据ID获取对象
-func getObjectByID(id int) (Object, error) {
-	var obj Object
-	err := db.QueryRow("SELECT id, name, data, description, folder_id,possible_from FROM objects WHERE id = ?", id).Scan(
-		&obj.ID, &obj.Name, &obj.Data, &obj.Description, &obj.FolderID, &obj.PossibleFrom)
-	return obj, err
+// 文件夹是否存在且属于该用户
+func folderExists(userID, id int) (bool, error) {
+	var count int
+	err := db.QueryRow("SELECT COUNT(*) FROM folders WHERE id = ? AND user_id = ?", id, userID).Scan(&count)
+	return count > 0, err
 }
 
 // 创建文件夹
-func createFolder(name string, upper int) (int, error) {
+func createFolder(userID int, name string, upper int, description string) (int, error) {
+	exists, err := folderExists(userID, upper)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, validationError("父文件夹不存在")
+	}
+
 	// 检查同一父文件夹下是否已存在同名文件夹
 	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM folders WHERE name = ? AND upper = ?", name, upper).Scan(&count)
+	err = db.QueryRow("SELECT COUNT(*) FROM folders WHERE name = ? AND upper = ?", name, upper).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
 
 	if count > 0 {
-		return 0, fmt.Errorf("文件夹 '%s' 在当前位置已存在", name)
+		return 0, validationError(fmt.Sprintf("文件夹 '%s' 在当前位置已存在", name))
 	}
 
-	result, err := db.Exec("INSERT INTO folders (name, upper) VALUES (?, ?)", name, upper)
+	result, err := db.Exec("INSERT INTO folders (name, upper, description, user_id) VALUES (?, ?, ?, ?)", name, upper, description, userID)
 	if err != nil {
 		return 0, err
 	}
@@ -520,56 +492,75 @@ func createFolder(name string, upper int) (int, error) {
 	return int(id), nil
 }
 
-// 更新文件夹
-func updateFolder(id int, name string, upper int) error {
-	_, err := db.Exec("UPDATE folders SET name = ?, upper = ? WHERE id = ?", name, upper, id)
-	return err
-}
-
-// 删除文件夹
-func deleteFolder(id int) error {
-	// 检查是否为根文件夹
-	if id == 0 {
-		return fmt.Errorf("不能删除根文件夹")
+// 更新文件夹，移动时校验目标父文件夹存在且不会形成环
+func updateFolder(userID, id int, name string, upper int, description *string) error {
+	var current Folder
+	err := db.QueryRow("SELECT id, name, upper FROM folders WHERE id = ? AND user_id = ?", id, userID).
+		Scan(&current.ID, &current.Name, &current.Upper)
+	if err == sql.ErrNoRows {
+		return validationError("文件夹不存在")
 	}
-
-	// 检查文件夹是否存在
-	var count int
-	err := db.QueryRow("SELECT COUNT(*) FROM folders WHERE id = ?", id).Scan(&count)
 	if err != nil {
 		return err
 	}
-	if count == 0 {
-		return fmt.Errorf("文件夹不存在")
-	}
 
-	// 检查是否有子文件夹
-	err = db.QueryRow("SELECT COUNT(*) FROM folders WHERE upper = ?", id).Scan(&count)
-	if err != nil {
-		return err
-	}
-	if count > 0 {
-		return fmt.Errorf("文件夹不为空，请先删除子文件夹")
+	// 根文件夹只能重命名
+	if current.ID == current.Upper {
+		if upper != current.Upper {
+			return validationError("不能移动根文件夹")
+		}
+	} else if upper != current.Upper {
+		if upper == id {
+			return validationError("不能将文件夹移动到自身")
+		}
+
+		exists, err := folderExists(userID, upper)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return validationError("目标父文件夹不存在")
+		}
+
+		// 目标不能是自己的子孙文件夹
+		descendants, err := getDescendantFolderIDs(id)
+		if err != nil {
+			return err
+		}
+		for _, descendant := range descendants {
+			if descendant == upper {
+				return validationError("不能将文件夹移动到自己的子文件夹中")
+			}
+		}
 	}
 
-	// 检查是否有对象
-	err = db.QueryRow("SELECT COUNT(*) FROM objects WHERE folder_id = ?", id).Scan(&count)
+	// 检查目标位置是否已存在同名文件夹
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM folders WHERE name = ? AND upper = ? AND id != ?", name, upper, id).Scan(&count)
 	if err != nil {
 		return err
 	}
 	if count > 0 {
-		return fmt.Errorf("文件夹不为空，请先删除文件夹中的图片")
+		return validationError(fmt.Sprintf("文件夹 '%s' 在目标位置已存在", name))
 	}
 
-	// 删除文件夹
-	_, err = db.Exec("DELETE FROM folders WHERE id = ?", id)
+	// description 为 nil 时保持不变
+	_, err = db.Exec("UPDATE folders SET name = ?, upper = ?, description = COALESCE(?, description) WHERE id = ?", name, upper, description, id)
 	return err
 }
 
+// 请求参数的校验错误（文件夹、用户、规则等），接口返回400
+type validationError string
+
+func (e validationError) Error() string {
+	return string(e)
+}
+
 // 获取子文件夹
-func getSubFolders(parentID int) ([]Folder, error) {
+func getSubFolders(userID, parentID int) ([]Folder, error) {
 	// 查询子文件夹，排除父文件夹本身
-	rows, err := db.Query("SELECT id, name, upper FROM folders WHERE upper = ? AND id != ?", parentID, parentID)
+	rows, err := db.Query("SELECT id, name, upper, description FROM folders WHERE upper = ? AND id != ? AND user_id = ?",
+		parentID, parentID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -578,7 +569,7 @@ func getSubFolders(parentID int) ([]Folder, error) {
 	var folders []Folder
 	for rows.Next() {
 		var folder Folder
-		if err := rows.Scan(&folder.ID, &folder.Name, &folder.Upper); err != nil {
+		if err := rows.Scan(&folder.ID, &folder.Name, &folder.Upper, &folder.Description); err != nil {
 			continue
 		}
 		folders = append(folders, folder)
@@ -587,9 +578,207 @@ func getSubFolders(parentID int) ([]Folder, error) {
 	return folders, nil
 }
 
+// 对象列表的过滤和排序条件
+type ObjectFilter struct {
+	UserID    int // 对象所属用户，0 表示不限用户，只用于后台维护
+	FolderIDs []int
+	AppName   string // 来源应用，精确匹配（不区分大小写）
+	Tags      []string
+	From      int64 // 截图时间戳下限（毫秒，含）
+	To        int64 // 截图时间戳上限（毫秒，不含）
+	AfterID   int   // 只返回ID大于它的对象，用于分批处理
+	Sort      string
+	Order     string
+}
+
+// 允许排序的列
+var objectSortColumns = map[string]string{
+	"id":                   "id",
+	"name":                 "name",
+	"screenshot_timestamp": "screenshot_timestamp",
+	"created_at":           "created_at",
+}
+
+// 生成过滤条件对应的SQL片段和参数
+func (f ObjectFilter) where() (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	if f.UserID > 0 {
+		clauses = append(clauses, "user_id = ?")
+		args = append(args, f.UserID)
+	}
+	if len(f.FolderIDs) > 0 {
+		clauses = append(clauses, "folder_id IN ("+placeholders(len(f.FolderIDs))+")")
+		for _, id := range f.FolderIDs {
+			args = append(args, id)
+		}
+	}
+	if f.AppName != "" {
+		clauses = append(clauses, "screenshot_app_name = ? COLLATE NOCASE")
+		args = append(args, f.AppName)
+	}
+	// 标签以逗号分隔存储，每个标签都必须出现
+	for _, tag := range f.Tags {
+		clauses = append(clauses, `(',' || screenshot_tags || ',') LIKE ? ESCAPE '\'`)
+		args = append(args, "%,"+escapeLike(tag)+",%")
+	}
+	if f.From > 0 {
+		clauses = append(clauses, "screenshot_timestamp >= ?")
+		args = append(args, f.From)
+	}
+	if f.To > 0 {
+		clauses = append(clauses, "screenshot_timestamp < ?")
+		args = append(args, f.To)
+	}
+	if f.AfterID > 0 {
+		clauses = append(clauses, "id > ?")
+		args = append(args, f.AfterID)
+	}
+	if len(clauses) == 0 {
+		return "", nil
+	}
+	return " AND " + strings.Join(clauses, " AND "), args
+}
+
+// 除用户外是否还有其他过滤条件
+func (f ObjectFilter) hasConditions() bool {
+	f.UserID = 0
+	where, _ := f.where()
+	return where != ""
+}
+
+func (f ObjectFilter) orderBy() string {
+	column, ok := objectSortColumns[f.Sort]
+	if !ok {
+		column = "id"
+	}
+	order := "ASC"
+	if strings.EqualFold(f.Order, "desc") {
+		order = "DESC"
+	}
+	return fmt.Sprintf(" ORDER BY %s %s, id %s", column, order, order)
+}
+
+// 生成 n 个SQL占位符
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?,", n), ",")
+}
+
+// 批量获取满足过滤条件的对象
+func getObjectsByIDs(ids []int, filter ObjectFilter) (map[int]Object, error) {
+	objects := make(map[int]Object)
+	if len(ids) == 0 {
+		return objects, nil
+	}
+
+	where, filterArgs := filter.where()
+	args := make([]interface{}, 0, len(ids)+len(filterArgs))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	args = append(args, filterArgs...)
+
+	rows, err := db.Query("SELECT "+objectColumns+" FROM objects WHERE id IN ("+placeholders(len(ids))+")"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			continue
+		}
+		objects[obj.ID] = obj
+	}
+	return objects, rows.Err()
+}
+
+// 按过滤条件和排序获取对象ID
+func getObjectIDs(filter ObjectFilter, limit int) ([]int, error) {
+	where, args := filter.where()
+	args = append(args, limit)
+	rows, err := db.Query("SELECT id FROM objects WHERE 1 = 1"+where+filter.orderBy()+" LIMIT ?", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// 获取文件夹及其所有子孙文件夹的ID
+func getDescendantFolderIDs(folderID int) ([]int, error) {
+	return queryDescendantFolderIDs(db, folderID)
+}
+
+func queryDescendantFolderIDs(q queryer, folderID int) ([]int, error) {
+	// UNION 去重，即使存在环也能终止；根文件夹的 upper 指向自身，需要排除
+	rows, err := q.Query(`
+	WITH RECURSIVE subtree(id) AS (
+		SELECT ?
+		UNION
+		SELECT f.id FROM folders f JOIN subtree s ON f.upper = s.id WHERE f.id != f.upper
+	)
+	SELECT id FROM subtree`, folderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// 转义 LIKE 模式中的通配符
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+// 获取满足过滤条件的全部对象
+func findObjects(filter ObjectFilter) ([]Object, error) {
+	return queryObjects(db, filter)
+}
+
+func queryObjects(q queryer, filter ObjectFilter) ([]Object, error) {
+	where, args := filter.where()
+	rows, err := q.Query("SELECT "+objectColumns+" FROM objects WHERE 1 = 1"+where+filter.orderBy(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []Object
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
 // 获取文件夹中的对象
-func getObjectsInFolder(folderID int) ([]Object, error) {
-	rows, err := db.Query("SELECT id, name, data, description, folder_id,possible_from FROM objects WHERE folder_id = ?", folderID)
+func getObjectsInFolder(folderID int, filter ObjectFilter) ([]Object, error) {
+	where, args := filter.where()
+	args = append([]interface{}{folderID}, args...)
+	rows, err := db.Query("SELECT "+objectColumns+" FROM objects WHERE folder_id = ?"+where+filter.orderBy(), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -597,8 +786,8 @@ func getObjectsInFolder(folderID int) ([]Object, error) {
 
 	var objects []Object
 	for rows.Next() {
-		var obj Object
-		if err := rows.Scan(&obj.ID, &obj.Name, &obj.Data, &obj.Description, &obj.FolderID, &obj.PossibleFrom); err != nil {
+		obj, err := scanObject(rows)
+		if err != nil {
 			continue
 		}
 		objects = append(objects, obj)
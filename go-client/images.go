@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 允许的缩略图尺寸（长边像素），请求的尺寸向上取整到其中之一，避免缓存无限膨胀
+var thumbnailSizes = []int{64, 128, 256, 512, 1024}
+
+const defaultThumbnailSize = 256
+
+// 生成缩略图时解码的最大像素数。解码和铺底各需要一份全尺寸像素，
+// 声明了超大尺寸的小文件（解压炸弹）会耗尽内存，超过时直接返回原图
+const maxThumbnailPixels = 40_000_000
+
+var (
+	errUnsupportedImage = errors.New("unsupported image format")
+	errImageTooLarge    = errors.New("image too large for thumbnail")
+)
+
+// 根据路径参数加载有图片的对象，失败时直接写入错误响应
+func loadObjectForImage(c *gin.Context) (Object, bool) {
+	obj, ok := loadObject(c)
+	if !ok {
+		return Object{}, false
+	}
+
+	if obj.BlobHash == "" {
+		c.JSON(404, gin.H{"error": "Object has no image"})
+		return Object{}, false
+	}
+	return obj, true
+}
+
+// 以 ETag + 长缓存的方式返回文件，Range 和条件请求由 http.ServeContent 处理
+func serveImageFile(c *gin.Context, path, contentType, etag string) {
+	f, err := os.Open(path)
+	if err != nil {
+		c.JSON(404, gin.H{"error": "Image file not found"})
+		return
+	}
+	defer f.Close()
+
+	stat, err := f.Stat()
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to read image: %v", err)})
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("ETag", etag)
+	// 内容按哈希寻址，同一个URL的内容不会变化
+	c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(c.Writer, c.Request, "", stat.ModTime(), f)
+}
+
+// 获取原图处理器
+func getObjectImageHandler(c *gin.Context) {
+	obj, ok := loadObjectForImage(c)
+	if !ok {
+		return
+	}
+
+	serveImageFile(c, blobPath(obj.BlobHash), obj.MimeType, fmt.Sprintf(`"%s"`, obj.BlobHash))
+}
+
+// 获取缩略图处理器
+func getObjectThumbnailHandler(c *gin.Context) {
+	obj, ok := loadObjectForImage(c)
+	if !ok {
+		return
+	}
+
+	size := defaultThumbnailSize
+	if sizeParam := c.Query("size"); sizeParam != "" {
+		requested, err := strconv.Atoi(sizeParam)
+		if err != nil || requested <= 0 {
+			c.JSON(400, gin.H{"error": "Invalid thumbnail size"})
+			return
+		}
+		size = snapThumbnailSize(requested)
+	}
+
+	path, err := ensureThumbnail(obj.BlobHash, size)
+	if err == errUnsupportedImage || err == errImageTooLarge {
+		// 无法解码的格式（如WebP）和尺寸过大的图片直接返回原图
+		serveImageFile(c, blobPath(obj.BlobHash), obj.MimeType, fmt.Sprintf(`"%s"`, obj.BlobHash))
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to generate thumbnail: %v", err)})
+		return
+	}
+
+	serveImageFile(c, path, "image/jpeg", fmt.Sprintf(`"%s-%d"`, obj.BlobHash, size))
+}
+
+// 将请求的尺寸向上取整到允许的尺寸
+func snapThumbnailSize(requested int) int {
+	for _, s := range thumbnailSizes {
+		if requested <= s {
+			return s
+		}
+	}
+	return thumbnailSizes[len(thumbnailSizes)-1]
+}
+
+// 缩略图缓存路径
+func thumbnailPath(hash string, size int) string {
+	return filepath.Join(config.BlobDir, "thumbs", hash[:2], fmt.Sprintf("%s_%d.jpg", hash, size))
+}
+
+// 生成并缓存缩略图，已存在时直接返回路径
+func ensureThumbnail(hash string, size int) (string, error) {
+	path := thumbnailPath(hash, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	f, err := os.Open(blobPath(hash))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	// 先只读取头部的尺寸，确认不超过上限再解码
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return "", errUnsupportedImage
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || int64(cfg.Width)*int64(cfg.Height) > maxThumbnailPixels {
+		return "", errImageTooLarge
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	src, _, err := image.Decode(f)
+	if err != nil {
+		return "", errUnsupportedImage
+	}
+
+	thumb := resizeImage(src, size)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", err
+	}
+	// 先写临时文件再重命名，并发请求同一缩略图时互不影响
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	if err := jpeg.Encode(tmp, thumb, &jpeg.Options{Quality: 80}); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return path, nil
+}
+
+// 删除图片的全部缩略图
+func removeThumbnails(hash string) {
+	matches, _ := filepath.Glob(filepath.Join(config.BlobDir, "thumbs", hash[:2], hash+"_*.jpg"))
+	for _, path := range matches {
+		os.Remove(path)
+	}
+}
+
+// 按长边缩放到 maxSide，使用区域平均避免锯齿；不放大小图
+func resizeImage(src image.Image, maxSide int) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	// 先铺白底，透明PNG转JPEG时不会变黑
+	rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(rgba, rgba.Bounds(), src, b.Min, draw.Over)
+
+	if w <= maxSide && h <= maxSide {
+		return rgba
+	}
+
+	dw, dh := maxSide, maxSide
+	if w >= h {
+		dh = max(1, h*maxSide/w)
+	} else {
+		dw = max(1, w*maxSide/h)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		sy0, sy1 := y*h/dh, max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			sx0, sx1 := x*w/dw, max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, bl, n int
+			for sy := sy0; sy < sy1; sy++ {
+				off := rgba.PixOffset(sx0, sy)
+				for sx := sx0; sx < sx1; sx++ {
+					r += int(rgba.Pix[off])
+					g += int(rgba.Pix[off+1])
+					bl += int(rgba.Pix[off+2])
+					off += 4
+					n++
+				}
+			}
+
+			off := dst.PixOffset(x, y)
+			dst.Pix[off] = uint8(r / n)
+			dst.Pix[off+1] = uint8(g / n)
+			dst.Pix[off+2] = uint8(bl / n)
+			dst.Pix[off+3] = 255
+		}
+	}
+	return dst
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// 对象索引状态：对象先写入数据库，再写入向量库和全文索引。
+// 两者不在同一个事务中，索引失败时记录在对象上并由后台重试，避免对象入库后无法被搜索到。
+
+const (
+	IndexPending = "pending" // 已入库，等待写入索引
+	IndexIndexed = "indexed" // 向量库和全文索引都已写入
+	IndexFailed  = "failed"  // 写入失败，等待后台重试
+)
+
+// 上传任务入库后立即写入索引，超过这个时间仍为 pending 说明任务中断了，由后台补做
+const pendingIndexGrace = 10 * time.Minute
+
+// 按对象保存的搜索内容写入向量文档和全文索引，并记录结果
+func indexObject(obj Object) error {
+	reindexMu.RLock()
+	defer reindexMu.RUnlock()
+	return writeObjectIndex(obj)
+}
+
+// 写入对象的索引，调用方需持有 reindexMu
+func writeObjectIndex(obj Object) error {
+	ctx := context.Background()
+
+	// 先清理之前失败时留下的部分文档
+	err := deleteObjectVectors(ctx, obj.UserID, obj.ID)
+	if err == nil {
+		err = storeInVectorDB(obj)
+	}
+	if err == nil {
+		err = indexObjectText(obj)
+	}
+	if err != nil {
+		if cleanupErr := deleteObjectVectors(ctx, obj.UserID, obj.ID); cleanupErr != nil {
+			slog.Warn("清理部分向量文档失败", "object_id", obj.ID, "err", cleanupErr)
+		}
+		if markErr := markIndexFailed(obj.ID, err); markErr != nil {
+			slog.Error("记录索引状态失败", "object_id", obj.ID, "err", markErr)
+		}
+		return err
+	}
+	return markIndexed(obj)
+}
+
+func markIndexed(obj Object) error {
+	result, err := db.Exec("UPDATE objects SET index_state = ?, index_error = '', index_next_attempt_at = NULL WHERE id = ?",
+		IndexIndexed, obj.ID)
+	if err != nil {
+		return err
+	}
+	// 索引期间对象被删除了，清理刚写入的索引
+	if n, _ := result.RowsAffected(); n == 0 {
+		if err := deleteObjectVectors(context.Background(), obj.UserID, obj.ID); err != nil {
+			return err
+		}
+		return deleteObjectText(obj.ID)
+	}
+	return nil
+}
+
+// 记录失败并安排下次重试，间隔随失败次数翻倍，最长一小时
+func markIndexFailed(objectID int, indexErr error) error {
+	_, err := db.Exec(`UPDATE objects SET index_state = ?, index_error = ?, index_attempts = index_attempts + 1,
+		index_next_attempt_at = datetime('now', '+' || min(1 << index_attempts, 60) || ' minutes')
+		WHERE id = ?`, IndexFailed, indexErr.Error(), objectID)
+	return err
+}
+
+// 启动后台重试，定期重新索引到期的失败对象和中断的 pending 对象
+func startIndexRetryWorker(interval time.Duration, maxAttempts int) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if err := retryFailedIndexing(maxAttempts); err != nil {
+				slog.Error("重试索引失败", "err", err)
+			}
+		}
+	}()
+}
+
+func retryFailedIndexing(maxAttempts int) error {
+	rows, err := db.Query(`SELECT id FROM objects
+		WHERE index_attempts < ? AND (
+			(index_state = ? AND (index_next_attempt_at IS NULL OR index_next_attempt_at <= datetime('now')))
+			OR (index_state = ? AND created_at <= datetime('now', ?)))
+		ORDER BY id LIMIT 50`, maxAttempts, IndexFailed, IndexPending, fmt.Sprintf("-%d seconds", int(pendingIndexGrace.Seconds())))
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		// 重新读取对象，使用最新的名称、文件夹和搜索内容
+		obj, err := getObjectByID(id)
+		if err != nil {
+			continue
+		}
+		if err := indexObject(obj); err != nil {
+			slog.Warn("重试索引失败", "object_id", id, "attempt", obj.IndexAttempts+1, "err", err)
+			continue
+		}
+		slog.Info("重试索引成功", "object_id", id)
+	}
+	return nil
+}
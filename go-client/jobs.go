@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 上传任务状态
+const (
+	JobQueued      = "queued"
+	JobAnalyzing   = "analyzing"
+	JobClassifying = "classifying"
+	JobIndexing    = "indexing"
+	JobDone        = "done"
+	JobFailed      = "failed"
+)
+
+var errQueueFull = errors.New("upload queue is full")
+
+// 上传任务
+type Job struct {
+	ID        string          `json:"id"`
+	UserID    int             `json:"-"`
+	Status    string          `json:"status"`
+	Error     string          `json:"error,omitempty"`
+	ObjectID  int             `json:"object_id,omitempty"`
+	Result    json.RawMessage `json:"result,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// 任务载荷：图片已写入blob存储，只保留哈希
+type uploadJobPayload struct {
+	UploadRequest
+	Blob      Blob   `json:"blob"`
+	RequestID string `json:"request_id,omitempty"` // 提交任务的请求，用于关联日志
+}
+
+// 待处理任务队列，只传递任务ID，任务内容以数据库为准
+var jobQueue chan string
+
+// 启动工作协程，并恢复上次未完成的任务
+func startJobWorkers(workers, queueSize int) error {
+	jobQueue = make(chan string, queueSize)
+	for i := 0; i < workers; i++ {
+		go jobWorker()
+	}
+
+	// 重启前正在处理的任务重新排队
+	rows, err := db.Query("SELECT id FROM jobs WHERE status NOT IN (?, ?) ORDER BY created_at", JobDone, JobFailed)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var pending []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			continue
+		}
+		pending = append(pending, id)
+	}
+	if len(pending) > 0 {
+		slog.Info("恢复未完成的上传任务", "count", len(pending))
+		// 恢复的任务可能超过队列容量，放到后台逐个入队
+		go func() {
+			for _, id := range pending {
+				jobQueue <- id
+			}
+		}()
+	}
+	return rows.Err()
+}
+
+// 生成任务ID
+func newJobID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// 写入图片，持久化用户的上传请求并加入队列；没有入队时删除不再被引用的图片
+func enqueueUploadJob(userID int, imageData []byte, job uploadJobPayload) (string, error) {
+	id, err := newJobID()
+	if err != nil {
+		return "", err
+	}
+	if err := insertUploadJob(id, userID, imageData, &job); err != nil {
+		return "", err
+	}
+
+	select {
+	case jobQueue <- id:
+		return id, nil
+	default:
+		db.Exec("DELETE FROM jobs WHERE id = ?", id)
+		if err := releaseBlob(job.Blob.Hash); err != nil {
+			slog.Error("清理图片失败", "blob_hash", job.Blob.Hash, "err", err)
+		}
+		return "", errQueueFull
+	}
+}
+
+// 写入图片和任务记录，两步在 blobMu 内完成，图片写入后立即有任务引用
+func insertUploadJob(id string, userID int, imageData []byte, job *uploadJobPayload) error {
+	blobMu.Lock()
+	defer blobMu.Unlock()
+
+	blob, err := putBlob(imageData)
+	if err != nil {
+		return fmt.Errorf("failed to store image: %v", err)
+	}
+	job.Blob = blob
+
+	payload, err := json.Marshal(job)
+	if err == nil {
+		_, err = db.Exec("INSERT INTO jobs (id, user_id, status, payload, blob_hash) VALUES (?, ?, ?, ?, ?)",
+			id, userID, JobQueued, string(payload), blob.Hash)
+	}
+	if err != nil {
+		if removeErr := removeUnusedBlob(blob.Hash); removeErr != nil {
+			slog.Error("清理图片失败", "blob_hash", blob.Hash, "err", removeErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// 根据ID获取任务
+func getJob(id string) (Job, error) {
+	var job Job
+	var result string
+	err := db.QueryRow("SELECT id, user_id, status, error, object_id, result, created_at, updated_at FROM jobs WHERE id = ?", id).Scan(
+		&job.ID, &job.UserID, &job.Status, &job.Error, &job.ObjectID, &result, &job.CreatedAt, &job.UpdatedAt)
+	if result != "" {
+		job.Result = json.RawMessage(result)
+	}
+	return job, err
+}
+
+// 更新任务状态
+func setJobStatus(id, status string) {
+	if _, err := db.Exec("UPDATE jobs SET status = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", status, id); err != nil {
+		slog.Error("更新任务状态失败", "job_id", id, "err", err)
+	}
+}
+
+// 标记任务失败
+func failJob(id string, jobErr error) {
+	slog.Error("上传任务失败", "job_id", id, "err", jobErr)
+
+	// 任务失败后图片没有对象引用时一并删除
+	var blobHash string
+	db.QueryRow("SELECT blob_hash FROM jobs WHERE id = ?", id).Scan(&blobHash)
+
+	if _, err := db.Exec("UPDATE jobs SET status = ?, error = ?, payload = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		JobFailed, jobErr.Error(), id); err != nil {
+		slog.Error("更新任务状态失败", "job_id", id, "err", err)
+		return
+	}
+	if err := releaseBlob(blobHash); err != nil {
+		slog.Error("清理图片失败", "job_id", id, "blob_hash", blobHash, "err", err)
+	}
+}
+
+// 标记任务完成，并清理已不再需要的图片数据
+func finishJob(id string, result gin.H) {
+	data, _ := json.Marshal(result)
+	if _, err := db.Exec("UPDATE jobs SET status = ?, result = ?, payload = '', updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+		JobDone, string(data), id); err != nil {
+		slog.Error("更新任务状态失败", "job_id", id, "err", err)
+	}
+}
+
+func jobWorker() {
+	for id := range jobQueue {
+		if err := processUploadJob(id); err != nil {
+			failJob(id, err)
+		}
+	}
+}
+
+// 执行上传流水线：分析 -> 分类 -> 入库和索引
+func processUploadJob(id string) error {
+	var payload string
+	var userID, objectID int
+	err := db.QueryRow("SELECT payload, user_id, object_id FROM jobs WHERE id = ?", id).Scan(&payload, &userID, &objectID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load job: %v", err)
+	}
+
+	var job uploadJobPayload
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		return fmt.Errorf("invalid job payload: %v", err)
+	}
+	ctx := withLogAttrs(context.Background(), slog.String("job_id", id), slog.Int("user_id", userID))
+	if job.RequestID != "" {
+		ctx = withLogAttrs(ctx, slog.String("request_id", job.RequestID))
+	}
+	slog.DebugContext(ctx, "开始处理上传任务")
+
+	// 对象已入库时任务是重启后恢复的，只需补做索引，不再调用模型
+	if objectID != 0 {
+		return resumeUploadJob(ctx, id, objectID)
+	}
+
+	// 旧版本的任务载荷直接包含base64图片
+	if job.Blob.Hash == "" {
+		data, err := decodeImageBlob(job.ScreenshotFileBlob)
+		if err != nil {
+			return fmt.Errorf("invalid screenshot file blob: %v", err)
+		}
+		blobMu.Lock()
+		job.Blob, err = putBlob(data)
+		if err == nil {
+			_, err = db.Exec("UPDATE jobs SET blob_hash = ? WHERE id = ?", job.Blob.Hash, id)
+		}
+		blobMu.Unlock()
+		if err != nil {
+			return fmt.Errorf("failed to store image: %v", err)
+		}
+	}
+
+	// 视觉模型需要base64编码的图片
+	req := job.UploadRequest
+	imageData, err := readBlob(job.Blob.Hash)
+	if err != nil {
+		return fmt.Errorf("failed to read image: %v", err)
+	}
+	req.ScreenshotFileBlob = base64.StdEncoding.EncodeToString(imageData)
+
+	// 调用视觉模型分析图片
+	setJobStatus(id, JobAnalyzing)
+	description, err := analyzeImage(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to analyze image: %v", err)
+	}
+
+	// 获取文件夹树信息
+	setJobStatus(id, JobClassifying)
+	folderTree, err := getFolderTree(userID)
+	if err != nil {
+		return fmt.Errorf("failed to get folder tree: %v", err)
+	}
+
+	// 先按分类规则确定文件夹，规则都不匹配时使用文本模型的推荐
+	rule, err := matchFolderRule(userID, req.ScreenshotAppName, req.ScreenshotTags, description)
+	if err != nil {
+		return fmt.Errorf("failed to match folder rules: %v", err)
+	}
+
+	// 使用文本模型生成多维度搜索内容
+	searchContent, err := processWithTextModel(ctx, description, folderTree)
+	if err != nil {
+		return fmt.Errorf("failed to process with text model: %v", err)
+	}
+	var folderSource string
+	searchContent.FolderID, folderSource, err = resolveUploadFolder(ctx, userID, rule, searchContent)
+	if err != nil {
+		return fmt.Errorf("failed to resolve folder: %v", err)
+	}
+	ruleID := 0
+	if rule != nil {
+		ruleID = rule.ID
+	}
+	possibleFrom := fmt.Sprintf("possible_from: %s , %s", searchContent.FromSite, searchContent.OriginContent)
+
+	// 创建Object并存储到数据库，重启后重试时复用已创建的对象
+	setJobStatus(id, JobIndexing)
+	obj := Object{
+		UserID:              userID,
+		Name:                searchContent.Name,
+		BlobHash:            job.Blob.Hash,
+		BlobSize:            job.Blob.Size,
+		MimeType:            job.Blob.MimeType,
+		Description:         description,
+		FolderID:            searchContent.FolderID,
+		PossibleFrom:        possibleFrom,
+		ScreenshotTimestamp: req.ScreenshotTimestamp,
+		ScreenshotAppName:   req.ScreenshotAppName,
+		ScreenshotTags:      req.ScreenshotTags,
+		SuggestedFolderID:   searchContent.SuggestedFolder,
+		FolderConfidence:    searchContent.Confidence,
+		FolderSource:        folderSource,
+		Digest:              searchContent.Digest,
+		Keywords:            searchContent.Keywords,
+		Questions:           searchContent.Questions,
+		Scenario:            searchContent.Scenario,
+		FromSite:            searchContent.FromSite,
+		OriginContent:       searchContent.OriginContent,
+	}
+	objectID, err = createObject(obj)
+	if err != nil {
+		return fmt.Errorf("failed to create object: %v", err)
+	}
+	if _, err := db.Exec("UPDATE jobs SET object_id = ? WHERE id = ?", objectID, id); err != nil {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	obj.ID = objectID
+
+	// 将多维度内容写入向量库和全文索引，失败时对象保留，由后台重试
+	indexState := IndexIndexed
+	if err := indexObject(obj); err != nil {
+		slog.WarnContext(ctx, "索引失败，稍后重试", "object_id", objectID, "err", err)
+		indexState = IndexFailed
+	}
+
+	finishJob(id, uploadJobResult(obj, ruleID, indexState))
+	return nil
+}
+
+// 恢复已创建对象的任务：重新读取对象并写入索引
+func resumeUploadJob(ctx context.Context, id string, objectID int) error {
+	obj, err := getObjectByID(objectID)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("object %d was deleted before indexing finished", objectID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load object: %v", err)
+	}
+
+	setJobStatus(id, JobIndexing)
+	indexState := IndexIndexed
+	if err := indexObject(obj); err != nil {
+		slog.WarnContext(ctx, "索引失败，稍后重试", "object_id", objectID, "err", err)
+		indexState = IndexFailed
+	}
+	// 规则ID没有保存在对象上，恢复的任务中为 0
+	finishJob(id, uploadJobResult(obj, 0, indexState))
+	return nil
+}
+
+// 任务完成时返回的结果
+func uploadJobResult(obj Object, ruleID int, indexState string) gin.H {
+	return gin.H{
+		"object_id":            obj.ID,
+		"description":          obj.Description,
+		"digest":               obj.Digest,
+		"folder_id":            obj.FolderID,
+		"folder_rule_id":       ruleID,
+		"folder_source":        obj.FolderSource,
+		"suggested_folder_id":  obj.SuggestedFolderID,
+		"folder_confidence":    obj.FolderConfidence,
+		"screenshot_timestamp": obj.ScreenshotTimestamp,
+		"screenshot_app_name":  obj.ScreenshotAppName,
+		"screenshot_tags":      obj.ScreenshotTags,
+		"possibleFrom":         obj.PossibleFrom,
+		"index_state":          indexState,
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 结构化日志：基于 log/slog，按 LOG_LEVEL 过滤，LOG_FORMAT=json 时输出JSON。
+// 请求ID和任务ID放在 context 中，记录日志时自动附加。
+// 密钥和截图内容在输出前统一脱敏，调用方只需使用约定的字段名。
+
+type LogConfig struct {
+	Level   string // debug | info | warn | error
+	Format  string // text | json
+	Content bool   // 是否输出截图内容，仅用于本地调试
+}
+
+// 名称中含有这些词（按 _ 分隔）的字段视为密钥，始终脱敏，如 api_key、access_token
+var secretKeyWords = map[string]bool{"key": true, "apikey": true, "token": true, "secret": true, "password": true, "authorization": true}
+
+// 截图内容及由其生成的文本，LOG_CONTENT=true 之外只输出长度
+var contentKeys = map[string]bool{
+	"body":           true,
+	"content":        true,
+	"description":    true,
+	"digest":         true,
+	"keywords":       true,
+	"questions":      true,
+	"scenario":       true,
+	"origin_content": true,
+	"prompt":         true,
+	"query":          true,
+	"image":          true,
+}
+
+// 出现在任意字符串值（包括错误信息）中的密钥
+var secretPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)bearer\s+[^\s"']+`),
+	regexp.MustCompile(tokenPrefix + `[0-9a-f]+`),
+	regexp.MustCompile(`sk-[A-Za-z0-9_-]{8,}`),
+	regexp.MustCompile(`(access_token|api_key|key)=[^&\s"']+`),
+}
+
+const redacted = "[REDACTED]"
+
+func isSecretKey(key string) bool {
+	for _, word := range strings.FieldsFunc(strings.ToLower(key), func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		if secretKeyWords[word] {
+			return true
+		}
+	}
+	return false
+}
+
+// 替换字符串中的密钥
+func redactSecrets(s string) string {
+	for _, pattern := range secretPatterns {
+		s = pattern.ReplaceAllStringFunc(s, func(match string) string {
+			if name, _, found := strings.Cut(match, "="); found {
+				return name + "=" + redacted
+			}
+			return redacted
+		})
+	}
+	return s
+}
+
+// 输出前处理每个字段
+func redactAttr(logContent bool) func(groups []string, a slog.Attr) slog.Attr {
+	return func(groups []string, a slog.Attr) slog.Attr {
+		if len(groups) == 0 && (a.Key == slog.TimeKey || a.Key == slog.LevelKey || a.Key == slog.MessageKey) {
+			return a
+		}
+		if isSecretKey(a.Key) {
+			return slog.String(a.Key, redacted)
+		}
+
+		value := a.Value.Resolve()
+		var s string
+		switch value.Kind() {
+		case slog.KindString:
+			s = value.String()
+		case slog.KindAny:
+			if err, ok := value.Any().(error); ok {
+				s = err.Error()
+			} else if !contentKeys[a.Key] {
+				return a
+			} else {
+				s = fmt.Sprint(value.Any())
+			}
+		default:
+			return a
+		}
+
+		if contentKeys[a.Key] && !logContent {
+			return slog.String(a.Key, fmt.Sprintf("[REDACTED len=%d]", len(s)))
+		}
+		return slog.String(a.Key, redactSecrets(s))
+	}
+}
+
+type logAttrsKey struct{}
+
+// 返回附加了日志字段的 context，之后用它记录的日志都带上这些字段
+func withLogAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logAttrsKey{}).([]slog.Attr)
+	merged := append(append([]slog.Attr{}, existing...), attrs...)
+	return context.WithValue(ctx, logAttrsKey{}, merged)
+}
+
+// 把 context 中的字段加到日志记录上
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(logAttrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+// 初始化全局日志，标准库 log 的输出也经过同一个 handler
+func initLogger(cfg LogConfig) error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(cfg.Level)); err != nil {
+		return fmt.Errorf("无效的 LOG_LEVEL %q，应为 debug、info、warn 或 error", cfg.Level)
+	}
+	opts := &slog.HandlerOptions{Level: level, ReplaceAttr: redactAttr(cfg.Content)}
+
+	var handler slog.Handler
+	switch strings.ToLower(cfg.Format) {
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("无效的 LOG_FORMAT %q，应为 text 或 json", cfg.Format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// 记录错误并退出
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// 客户端传入的请求ID只接受较短的字母数字，避免日志注入
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// 请求日志中间件：分配请求ID并在响应头 X-Request-ID 中返回，请求结束后记录一条访问日志。
+// 只记录路径不记录查询参数，access_token 不会进入日志
+func requestLogger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		requestID := c.GetHeader("X-Request-ID")
+		if !requestIDPattern.MatchString(requestID) {
+			requestID = newRequestID()
+		}
+		c.Set("request_id", requestID)
+		c.Header("X-Request-ID", requestID)
+		ctx := withLogAttrs(c.Request.Context(), slog.String("request_id", requestID))
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		} else if status >= 400 {
+			level = slog.LevelWarn
+		}
+		attrs := []any{
+			"method", c.Request.Method,
+			"path", c.Request.URL.Path,
+			"status", status,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"client_ip", c.ClientIP(),
+		}
+		if userID := c.GetInt("user_id"); userID != 0 {
+			attrs = append(attrs, "user_id", userID)
+		}
+		if len(c.Errors) > 0 {
+			attrs = append(attrs, "error", c.Errors.String())
+		}
+		slog.Log(ctx, level, "request", attrs...)
+	}
+}
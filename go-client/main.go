@@ -3,12 +3,16 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"math"
+	"net/http"
 	"os"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
@@ -21,15 +25,44 @@ type Folder struct {
 	ID    int    `json:"id" db:"id"`
 	Name  string `json:"name" db:"name"`
 	Upper int    `json:"upper" db:"upper"`
+
+	Description string `json:"description" db:"description"` // 帮助文本模型理解文件夹用途
 }
 
 type Object struct {
 	ID           int    `json:"id" db:"id"`
+	UserID       int    `json:"user_id" db:"user_id"`
 	Name         string `json:"name" db:"name"`
-	Data         string `json:"data" db:"data"`
+	BlobHash     string `json:"blob_hash" db:"blob_hash"` // 图片内容的sha256，文件位于blob存储
+	BlobSize     int    `json:"blob_size" db:"blob_size"`
+	MimeType     string `json:"mime_type" db:"mime_type"`
 	Description  string `json:"description" db:"description"`
 	FolderID     int    `json:"folder_id" db:"folder_id"`
 	PossibleFrom string `json:"possible_from" db:"possible_from"`
+
+	// 文本模型生成的搜索内容，用于向量和全文索引，用户可以修改
+	Digest        string   `json:"digest" db:"digest"`
+	Keywords      string   `json:"keywords" db:"keywords"` // 逗号分隔
+	Questions     []string `json:"questions" db:"questions"`
+	Scenario      string   `json:"scenario" db:"scenario"`
+	FromSite      string   `json:"from_site" db:"from_site"`
+	OriginContent string   `json:"origin_content" db:"origin_content"`
+
+	// 截图元数据，由客户端上传时提供
+	ScreenshotTimestamp int64     `json:"screenshot_timestamp" db:"screenshot_timestamp"` // 毫秒
+	ScreenshotAppName   string    `json:"screenshot_app_name" db:"screenshot_app_name"`
+	ScreenshotTags      string    `json:"screenshot_tags" db:"screenshot_tags"` // 逗号分隔
+	CreatedAt           time.Time `json:"created_at" db:"created_at"`
+
+	// 上传时的自动分类记录，便于核查模型推荐
+	SuggestedFolderID string  `json:"suggested_folder_id" db:"suggested_folder_id"` // 模型返回的原始值
+	FolderConfidence  float64 `json:"folder_confidence" db:"folder_confidence"`
+	FolderSource      string  `json:"folder_source" db:"folder_source"` // rule、model 或 inbox
+
+	// 向量库和全文索引的写入状态，失败时后台重试
+	IndexState    string `json:"index_state" db:"index_state"` // pending、indexed 或 failed
+	IndexError    string `json:"index_error,omitempty" db:"index_error"`
+	IndexAttempts int    `json:"index_attempts" db:"index_attempts"`
 }
 
 // API请求/响应结构
@@ -43,46 +76,101 @@ type UploadRequest struct {
 type SearchRequest struct {
 	Query string `json:"query"`
 	Limit int    `json:"limit,omitempty"`
+	Mode  string `json:"mode,omitempty"` // vector、keyword 或 hybrid（默认）
+
+	// 可选的结构化过滤条件
+	FolderID  *int     `json:"folder_id,omitempty"`
+	Recursive bool     `json:"recursive,omitempty"` // 同时搜索子文件夹
+	AppName   string   `json:"app_name,omitempty"`
+	Tags      []string `json:"tags,omitempty"`
+	From      int64    `json:"from,omitempty"` // 截图时间戳范围（毫秒）
+	To        int64    `json:"to,omitempty"`
 }
 
 type FolderRequest struct {
-	Name  string `json:"name"`
-	Upper int    `json:"upper"`
+	Name        string  `json:"name"`
+	Upper       int     `json:"upper"`
+	Description *string `json:"description,omitempty"` // 更新时不提供则保持不变
+}
+
+// 分类规则请求
+type FolderRuleRequest struct {
+	FolderID int    `json:"folder_id"`
+	Type     string `json:"type"`    // app_name、tag 或 keyword
+	Pattern  string `json:"pattern"` // 应用名、标签或正则表达式
+	Priority int    `json:"priority"`
+}
+
+// 创建令牌请求
+type APITokenRequest struct {
+	UserID *int     `json:"user_id,omitempty"` // 默认为当前用户
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"` // read、write、admin，默认 read
 }
 
-// 千问API响应结构
-type QwenVLResponse struct {
-	Output struct {
-		Choices []struct {
-			Message struct {
-				Content []struct {
-					Text string `json:"text"`
-				} `json:"content"`
-			} `json:"message"`
-		} `json:"choices"`
-	} `json:"output"`
+// 创建用户请求
+type UserRequest struct {
+	Name string `json:"name"`
 }
 
-type QwenTextResponse struct {
-	Output struct {
-		Text string `json:"text"`
-	} `json:"output"`
+// 重新分类请求，不指定 object_id 和 folder_id 时处理整个图库
+type ReclassifyRequest struct {
+	ObjectID  *int `json:"object_id,omitempty"`
+	FolderID  *int `json:"folder_id,omitempty"`
+	Recursive bool `json:"recursive,omitempty"` // 同时处理子文件夹
+	Apply     bool `json:"apply,omitempty"`     // 默认只预览
+	Limit     int  `json:"limit,omitempty"`     // 本批最多处理的对象数，不超过 RECLASSIFY_BATCH_SIZE
+	AfterID   int  `json:"after_id,omitempty"`  // 从ID大于它的对象开始，取上一批返回的 next_after_id
+}
+
+// 确认后的移动
+type ReclassifyMove struct {
+	ObjectID int `json:"object_id"`
+	FolderID int `json:"folder_id"`
+}
+
+type ReclassifyApplyRequest struct {
+	Moves []ReclassifyMove `json:"moves"`
+}
+
+// 对象更新请求，未提供的字段保持不变；修改搜索内容后会重新生成向量
+type ObjectUpdateRequest struct {
+	Name      *string   `json:"name,omitempty"`
+	FolderID  *int      `json:"folder_id,omitempty"`
+	Digest    *string   `json:"digest,omitempty"`
+	Keywords  *string   `json:"keywords,omitempty"`
+	Questions *[]string `json:"questions,omitempty"`
+	Scenario  *string   `json:"scenario,omitempty"`
 }
 
 // 全局变量
 var (
-	db         *sql.DB
-	vecDB      *chromem.DB
-	collection *chromem.Collection
-	config     Config
+	db     *sql.DB
+	vecDB  *chromem.DB
+	config Config
 )
 
 type Config struct {
-	QwenVLAPIKey   string
-	QwenTextAPIKey string
+	Vision         ModelConfig
+	Text           ModelConfig
 	OpenAIAPIKey   string
+	Offline        bool   // 离线模式：所有模型都走本地 Ollama
+	OllamaBaseURL  string // Ollama 接口地址，嵌入和本地模型共用
+	EmbeddingModel string
 	DBPath         string
+	BlobDir        string // 图片blob存储目录
 	Port           string
+	JobWorkers     int // 并发处理上传任务的协程数
+	JobQueueSize   int
+	InboxFolderID  int // 模型推荐的文件夹无效时存放上传对象的文件夹
+
+	IndexRetryInterval int // 重试失败索引的间隔（秒）
+	IndexMaxAttempts   int // 索引失败后最多重试的次数
+
+	ReclassifyBatchSize int // 一次重新分类最多处理的对象数，每个对象调用一次文本模型
+
+	CORS CORSConfig
+	Log  LogConfig
 }
 
 // 工具函数
@@ -93,56 +181,55 @@ func getEnv(key, defaultValue string) string {
 	return defaultValue
 }
 
-// 初始化SQLite数据库
-func initDB() error {
-	var err error
-	db, err = sql.Open("sqlite3", config.DBPath)
-	if err != nil {
-		return err
+func getEnvInt(key string, defaultValue int) int {
+	if value, err := strconv.Atoi(os.Getenv(key)); err == nil && value > 0 {
+		return value
 	}
+	return defaultValue
+}
 
-	// 创建表
-	folderTable := `
-	CREATE TABLE IF NOT EXISTS folders (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		upper INTEGER DEFAULT 0
-	);
-	`
-
-	objectTable := `
-	CREATE TABLE IF NOT EXISTS objects (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL,
-		data TEXT NOT NULL,
-		description TEXT NOT NULL,
-		folder_id INTEGER DEFAULT 0,
-		possible_from TEXT
-	);
-	`
-
-	if _, err := db.Exec(folderTable); err != nil {
-		return err
-	}
+// 打开SQLite数据库
+func openDB() error {
+	var err error
+	// 上传任务在多个协程中并发写库，等待锁而不是直接报错
+	db, err = sql.Open("sqlite3", config.DBPath+"?_busy_timeout=5000")
+	return err
+}
 
-	if _, err := db.Exec(objectTable); err != nil {
+// 初始化SQLite数据库：执行未完成的迁移并准备初始数据
+func initDB() error {
+	if err := openDB(); err != nil {
 		return err
 	}
 
-	// 为现有数据库添加name字段（如果不存在）
-	_, err = db.Exec("ALTER TABLE objects ADD COLUMN name TEXT DEFAULT ''")
-	if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+	if _, err := runMigrations(); err != nil {
 		return err
 	}
 
-	// 创建根文件夹（如果不存在）
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM folders WHERE id = 0").Scan(&count)
-	if err == nil && count == 0 {
-		_, err = db.Exec("INSERT INTO folders (id, name, upper) VALUES (0, 'Root', 0)")
+	// 默认用户及其根文件夹由迁移创建
+	return migrateObjectBlobs()
+}
+
+// 每个用户一个向量集合，默认用户沿用引入多用户之前的 instago 集合
+func vectorCollectionName(userID int) string {
+	if userID == DefaultUserID {
+		return "instago"
 	}
+	return fmt.Sprintf("instago_user_%d", userID)
+}
 
-	return err
+// 获取用户的向量集合，不存在时创建
+func userCollection(userID int) (*chromem.Collection, error) {
+	return vecDB.GetOrCreateCollection(vectorCollectionName(userID), nil, vectorEmbeddingFunc())
+}
+
+// 向量库使用的嵌入函数，优先使用Ollama嵌入函数
+func vectorEmbeddingFunc() chromem.EmbeddingFunc {
+	// 如果有OpenAI API密钥且需要使用，可以改为以下嵌入函数
+	// if config.OpenAIAPIKey != "" {
+	//		return chromem.NewEmbeddingFuncOpenAI(config.OpenAIAPIKey, chromem.EmbeddingModelOpenAI3Small)
+	// }
+	return chromem.NewEmbeddingFuncOllama(config.EmbeddingModel, config.OllamaBaseURL)
 }
 
 // 初始化向量数据库
@@ -154,23 +241,21 @@ func initVectorDB() error {
 		return fmt.Errorf("创建持久化向量数据库失败: %v", err)
 	}
 
-	// 创建或获取collection，使用本地默认嵌入函数
-	var embeddingFunc chromem.EmbeddingFunc
-
-	// 优先尝试使用Ollama嵌入函数（如果Ollama服务可用）
-	embeddingFunc = chromem.NewEmbeddingFuncOllama("nomic-embed-text", "http://localhost:11434/api")
-
-	// 如果有OpenAI API密钥且需要使用，可以取消注释以下代码
-	// if config.OpenAIAPIKey != "" {
-	//		embeddingFunc = chromem.NewEmbeddingFuncOpenAI(config.OpenAIAPIKey, chromem.EmbeddingModelOpenAI3Small)
-	// }
-
-	collection, err = vecDB.GetOrCreateCollection("instago", nil, embeddingFunc)
+	// 创建或获取每个用户的collection
+	users, err := listUsers()
 	if err != nil {
 		return err
 	}
+	total := 0
+	for _, user := range users {
+		collection, err := userCollection(user.ID)
+		if err != nil {
+			return err
+		}
+		total += collection.Count()
+	}
 
-	fmt.Printf("向量数据库初始化完成，当前文档数量: %d\n", collection.Count())
+	slog.Info("向量数据库初始化完成", "users", len(users), "vector_docs", total)
 	return nil
 }
 
@@ -193,50 +278,47 @@ func uploadHandler(c *gin.Context) {
 		return
 	}
 
-	// 调用千问视觉模型分析图片
-	description, err := analyzeImageWithQwenVL(req)
+	imageData, err := decodeImageBlob(req.ScreenshotFileBlob)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to analyze image: %v", err)})
+		c.JSON(400, gin.H{"error": "Screenshot file blob must be base64 encoded"})
 		return
 	}
-
-	// 获取文件夹树信息
-	folderTree, err := getFolderTree()
-	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folder tree: %v", err)})
+	if mimeType := http.DetectContentType(imageData); !strings.HasPrefix(mimeType, "image/") {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Screenshot file blob is not an image (%s)", mimeType)})
 		return
 	}
 
-	// 使用千问文本模型生成多维度搜索内容
-	searchContent, err := processWithQwenText(description, folderTree)
-	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to process with text model: %v", err)})
+	// 图片写入blob存储，任务中只保留哈希；加入后台队列，立即返回任务ID
+	req.ScreenshotFileBlob = ""
+	jobID, err := enqueueUploadJob(currentUserID(c), imageData, uploadJobPayload{UploadRequest: req, RequestID: c.GetString("request_id")})
+	if err == errQueueFull {
+		c.JSON(503, gin.H{"error": "Upload queue is full, please retry later"})
 		return
 	}
-	possibleFrom := fmt.Sprintf("possible_from: %s , %s", searchContent.FromSite, searchContent.OriginContent)
-	// 创建Object并存储到数据库
-	objectID, err := createObject(searchContent.Name, req.ScreenshotFileBlob, description, searchContent.FolderID, possibleFrom)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to create object: %v", err)})
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to enqueue upload: %v", err)})
 		return
 	}
 
-	// 将多维度内容向量化并存储到向量数据库
-	if err := storeInVectorDB(objectID, searchContent); err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to store in vector database: %v", err)})
+	c.JSON(202, gin.H{
+		"job_id": jobID,
+		"status": JobQueued,
+	})
+}
+
+// 查询上传任务处理器
+func getJobHandler(c *gin.Context) {
+	job, err := getJob(c.Param("id"))
+	if err == sql.ErrNoRows || (err == nil && job.UserID != currentUserID(c)) {
+		c.JSON(404, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get job: %v", err)})
 		return
 	}
 
-	c.JSON(200, gin.H{
-		"object_id":            objectID,
-		"description":          description,
-		"digest":               searchContent.Digest,
-		"folder_id":            searchContent.FolderID,
-		"screenshot_timestamp": req.ScreenshotTimestamp,
-		"screenshot_app_name":  req.ScreenshotAppName,
-		"screenshot_tags":      req.ScreenshotTags,
-		"possibleFrom":         possibleFrom,
-	})
+	c.JSON(200, job)
 }
 
 // 语义搜索处理器
@@ -255,113 +337,176 @@ func searchHandler(c *gin.Context) {
 	if req.Limit <= 0 {
 		req.Limit = 3
 	}
-	// 使用Ollama标准化查询
-	//standardizedQuery, err := standardizeQueryWithOllama(req.Query)
-	//if err != nil {
-	//	fmt.Printf("查询标准化失败，使用原查询: %v\n", err)
-	//	standardizedQuery = req.Query
-	//}
-	standardizedQuery := req.Query
 
-	// 检查集合中的文档数量，避免请求数量超过实际文档数量
-	ctx := context.Background()
-	docCount := collection.Count()
-	fmt.Println("docCount:", docCount)
-	if req.Limit > docCount {
-		req.Limit = docCount
+	mode := req.Mode
+	if mode == "" {
+		mode = SearchModeHybrid
 	}
-
-	// 如果集合为空，直接返回空结果
-	if docCount == 0 {
-		c.JSON(200, gin.H{
-			"results": []Object{},
-			"count":   0,
-		})
+	if mode != SearchModeVector && mode != SearchModeKeyword && mode != SearchModeHybrid {
+		c.JSON(400, gin.H{"error": "Invalid mode, must be vector, keyword or hybrid"})
 		return
 	}
 
-	// 计算实际查询数量，确保不超过文档总数
-	queryLimit := req.Limit * 3
-	if queryLimit > docCount {
-		queryLimit = docCount
-	}
+	// 解析"昨天下午"、"三天前"等相对时间，转换为截图时间范围并从查询中去掉
+	standardizedQuery, timeRange := parseTimeExpression(req.Query, time.Now())
 
-	// 在向量数据库中搜索
-	results, err := collection.Query(ctx, standardizedQuery, queryLimit, nil, nil)
-	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to search: %v", err)})
-		return
+	// 结构化过滤条件全部由SQL判断，向量检索只负责排序
+	userID := currentUserID(c)
+	filter := ObjectFilter{
+		UserID:  userID,
+		AppName: req.AppName,
+		Tags:    req.Tags,
+		From:    req.From,
+		To:      req.To,
 	}
-
-	// 处理搜索结果，去重并按相似度排序
-	objectMap := make(map[int]*gin.H)
-	for _, result := range results {
-		var objectID int
-		var err error
-
-		// 处理不同类型的文档ID
-		if strings.Contains(result.ID, "_keywords") {
-			// 关键词文档
-			idStr := strings.Replace(result.ID, "_keywords", "", 1)
-			objectID, err = strconv.Atoi(idStr)
-		} else if strings.Contains(result.ID, "_question_") {
-			// 问题文档
-			parts := strings.Split(result.ID, "_question_")
-			if len(parts) > 0 {
-				objectID, err = strconv.Atoi(parts[0])
+	if timeRange != nil {
+		// 与请求中显式指定的范围取交集
+		if timeRange.From > filter.From {
+			filter.From = timeRange.From
+		}
+		if filter.To == 0 || timeRange.To < filter.To {
+			filter.To = timeRange.To
+		}
+	}
+	if req.FolderID != nil {
+		if req.Recursive {
+			folderIDs, err := getDescendantFolderIDs(*req.FolderID)
+			if err != nil {
+				c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get subfolders: %v", err)})
+				return
 			}
+			filter.FolderIDs = folderIDs
 		} else {
-			// 主文档
-			objectID, err = strconv.Atoi(result.ID)
+			filter.FolderIDs = []int{*req.FolderID}
 		}
+	}
+	hasFilter := filter.hasConditions()
 
-		if err != nil {
-			continue // 跳过无效的ID
+	ctx := context.Background()
+
+	// 向量检索：按对象去重，保留每个对象的最高相似度
+	var vectorIDs []int
+	similarities := make(map[int]float32)
+	if standardizedQuery != "" && mode != SearchModeKeyword {
+		// 有过滤条件时命中可能被SQL过滤掉，需要对全部文档排序。
+		// 过滤条件不写入向量元数据：chromem 的 where 只支持字符串完全相等，无法表达时间范围、
+		// 多个文件夹、不区分大小写的应用名和标签包含，文件夹还会随移动变化。
+		// chromem 本来就对全部文档计算相似度，这里多出的只是排序和SQL校验的开销
+		queryLimit := req.Limit * 3
+		if hasFilter {
+			queryLimit = math.MaxInt32 // 由 queryVectorDB 截断为文档总数
 		}
 
-		// 如果已存在该对象，比较相似度并保留更高的
-		if existingObj, exists := objectMap[objectID]; exists {
-			if result.Similarity > (*existingObj)["similarity"].(float32) {
-				(*existingObj)["similarity"] = result.Similarity
+		results, err := queryVectorDB(ctx, userID, standardizedQuery, queryLimit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to search: %v", err)})
+			return
+		}
+		for _, result := range results {
+			objectID, err := objectIDFromDocID(result.ID)
+			if err != nil {
+				continue // 跳过无效的ID
+			}
+			if existing, ok := similarities[objectID]; ok {
+				if result.Similarity > existing {
+					similarities[objectID] = result.Similarity
+				}
+				continue
 			}
-			continue
+			similarities[objectID] = result.Similarity
+			vectorIDs = append(vectorIDs, objectID)
 		}
+		// 同一对象的多个文档命中顺序不一定按最高相似度排列，重新排序
+		sort.SliceStable(vectorIDs, func(i, j int) bool {
+			return similarities[vectorIDs[i]] > similarities[vectorIDs[j]]
+		})
+	}
 
-		obj, err := getObjectByID(objectID)
+	// 关键词检索：精确匹配错误码、用户名等向量检索容易漏掉的字符串
+	var keywordIDs []int
+	if standardizedQuery != "" && mode != SearchModeVector {
+		var err error
+		keywordIDs, err = keywordSearch(standardizedQuery, req.Limit*3, filter)
 		if err != nil {
-			continue // 跳过获取失败的对象
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to search: %v", err)})
+			return
 		}
+	}
 
-		objectMap[objectID] = &gin.H{
-			"id":          obj.ID,
-			"name":        obj.Name,
-			"description": obj.Description,
-			"folder_id":   obj.FolderID,
-			"similarity":  result.Similarity,
-			"data":        obj.Data,
+	// 查询只有时间短语：按截图时间倒序返回范围内的对象
+	var recentIDs []int
+	if standardizedQuery == "" {
+		filter.Sort, filter.Order = "screenshot_timestamp", "desc"
+		var err error
+		recentIDs, err = getObjectIDs(filter, req.Limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to list objects: %v", err)})
+			return
 		}
 	}
 
-	// 转换为数组并按相似度排序
-	var objects []gin.H
-	for _, obj := range objectMap {
-		objects = append(objects, *obj)
+	// 从数据库加载对象，同时应用SQL过滤条件
+	candidates := append(append(append([]int{}, vectorIDs...), keywordIDs...), recentIDs...)
+	objectsByID, err := getObjectsByIDs(candidates, filter)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to load objects: %v", err)})
+		return
 	}
 
-	// 按相似度降序排序
-	sort.Slice(objects, func(i, j int) bool {
-		return objects[i]["similarity"].(float32) > objects[j]["similarity"].(float32)
-	})
+	// 不存在或不满足过滤条件的对象不参与排名
+	vectorIDs = existingIDs(vectorIDs, objectsByID)
+	keywordIDs = existingIDs(keywordIDs, objectsByID)
+
+	var ranked []int
+	scores := make(map[int]float64)
+	switch {
+	case standardizedQuery == "":
+		ranked = recentIDs
+	case mode == SearchModeVector:
+		ranked = vectorIDs
+	case mode == SearchModeKeyword:
+		ranked = keywordIDs
+	default:
+		ranked, scores = fuseRankings(vectorIDs, keywordIDs)
+	}
 
 	// 限制返回结果数量
-	if len(objects) > req.Limit {
-		objects = objects[:req.Limit]
+	if len(ranked) > req.Limit {
+		ranked = ranked[:req.Limit]
+	}
+
+	objects := []gin.H{}
+	for _, objectID := range ranked {
+		obj := objectsByID[objectID]
+		result := gin.H{
+			"id":                   obj.ID,
+			"name":                 obj.Name,
+			"description":          obj.Description,
+			"folder_id":            obj.FolderID,
+			"similarity":           similarities[objectID],
+			"blob_hash":            obj.BlobHash,
+			"mime_type":            obj.MimeType,
+			"screenshot_timestamp": obj.ScreenshotTimestamp,
+			"screenshot_app_name":  obj.ScreenshotAppName,
+			"screenshot_tags":      obj.ScreenshotTags,
+			"created_at":           obj.CreatedAt,
+		}
+		if score, ok := scores[objectID]; ok {
+			result["score"] = score
+		}
+		objects = append(objects, result)
 	}
 
-	c.JSON(200, gin.H{
+	response := gin.H{
 		"results": objects,
 		"count":   len(objects),
-	})
+		"mode":    mode,
+	}
+	if timeRange != nil {
+		response["query"] = standardizedQuery
+		response["time_range"] = timeRange
+	}
+	c.JSON(200, response)
 }
 
 // 创建或更新文件夹处理器
@@ -387,18 +532,22 @@ func createOrUpdateFolderHandler(c *gin.Context) {
 			return
 		}
 
-		err = updateFolder(id, req.Name, req.Upper)
+		err = updateFolder(currentUserID(c), id, req.Name, req.Upper, req.Description)
 		if err != nil {
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to update folder: %v", err)})
+			c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to update folder: %v", err)})
 			return
 		}
 
 		c.JSON(200, gin.H{"message": "Folder updated successfully", "id": id})
 	} else {
 		// 创建新文件夹
-		id, err := createFolder(req.Name, req.Upper)
+		description := ""
+		if req.Description != nil {
+			description = *req.Description
+		}
+		id, err := createFolder(currentUserID(c), req.Name, req.Upper, description)
 		if err != nil {
-			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to create folder: %v", err)})
+			c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to create folder: %v", err)})
 			return
 		}
 
@@ -406,6 +555,26 @@ func createOrUpdateFolderHandler(c *gin.Context) {
 	}
 }
 
+// 校验错误返回400，其他错误返回500
+func validationErrorStatus(err error) int {
+	var ve validationError
+	if errors.As(err, &ve) {
+		return 400
+	}
+	return 500
+}
+
+// 获取完整文件夹树处理器
+func getFolderTreeHandler(c *gin.Context) {
+	tree, err := buildFolderTree(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folder tree: %v", err)})
+		return
+	}
+
+	c.JSON(200, tree)
+}
+
 // 获取文件夹内容处理器
 func getFolderContentsHandler(c *gin.Context) {
 	idParam := c.Param("id")
@@ -415,15 +584,47 @@ func getFolderContentsHandler(c *gin.Context) {
 		return
 	}
 
+	// 只能查看自己的文件夹
+	userID := currentUserID(c)
+	exists, err := folderExists(userID, id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folder: %v", err)})
+		return
+	}
+	if !exists {
+		c.JSON(404, gin.H{"error": "Folder not found"})
+		return
+	}
+
 	// 获取子文件夹
-	subFolders, err := getSubFolders(id)
+	subFolders, err := getSubFolders(userID, id)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get subfolders: %v", err)})
 		return
 	}
 
-	// 获取文件夹中的对象
-	objects, err := getObjectsInFolder(id)
+	// 获取文件夹中的对象，支持按截图元数据过滤和排序
+	filter := ObjectFilter{
+		UserID:  userID,
+		AppName: c.Query("app_name"),
+		Tags:    splitTags(c.Query("tags")),
+		Sort:    c.DefaultQuery("sort", "id"),
+		Order:   c.DefaultQuery("order", "asc"),
+	}
+	if filter.From, err = parseTimestampParam(c.Query("from")); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid from timestamp"})
+		return
+	}
+	if filter.To, err = parseTimestampParam(c.Query("to")); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid to timestamp"})
+		return
+	}
+	if _, ok := objectSortColumns[filter.Sort]; !ok {
+		c.JSON(400, gin.H{"error": "Invalid sort field"})
+		return
+	}
+
+	objects, err := getObjectsInFolder(id, filter)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get objects: %v", err)})
 		return
@@ -435,7 +636,18 @@ func getFolderContentsHandler(c *gin.Context) {
 	})
 }
 
+// 解析毫秒时间戳查询参数，空值返回0
+func parseTimestampParam(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
 // 删除文件夹处理器
+//
+// mode 为空时只能删除空文件夹；cascade 连同内容一起删除；move_to_parent 或 move_to=<id>
+// 把子文件夹和对象移走后删除。dry_run=true 时只返回影响范围
 func deleteFolderHandler(c *gin.Context) {
 	idParam := c.Param("id")
 	id, err := strconv.Atoi(idParam)
@@ -444,76 +656,217 @@ func deleteFolderHandler(c *gin.Context) {
 		return
 	}
 
-	err = deleteFolder(id)
+	mode := c.Query("mode")
+	target := 0
+	if moveTo := c.Query("move_to"); moveTo != "" {
+		if mode == FolderDeleteEmpty {
+			mode = FolderDeleteMoveTo
+		}
+		if target, err = strconv.Atoi(moveTo); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid move_to folder ID"})
+			return
+		}
+	} else if mode == FolderDeleteMoveTo {
+		c.JSON(400, gin.H{"error": "move_to folder ID is required"})
+		return
+	}
+
+	dryRun := false
+	if dryRunParam := c.Query("dry_run"); dryRunParam != "" {
+		if dryRun, err = strconv.ParseBool(dryRunParam); err != nil {
+			c.JSON(400, gin.H{"error": "Invalid dry_run"})
+			return
+		}
+	}
+
+	if dryRun {
+		plan, err := planFolderDelete(currentUserID(c), id, mode, target)
+		if err != nil {
+			c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to delete folder: %v", err)})
+			return
+		}
+		c.JSON(200, gin.H{"message": "Dry run, nothing was deleted", "dry_run": true, "plan": plan})
+		return
+	}
+
+	plan, warnings, err := deleteFolder(currentUserID(c), id, mode, target)
 	if err != nil {
-		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to delete folder: %v", err)})
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to delete folder: %v", err)})
 		return
 	}
 
-	c.JSON(200, gin.H{"message": "Folder deleted successfully"})
+	response := gin.H{"message": "Folder deleted successfully", "dry_run": false, "plan": plan}
+	if len(warnings) > 0 {
+		// 数据库已提交，重试删除没有意义，残留数据可用 reindex 修复
+		response["warnings"] = warnings
+	}
+	c.JSON(200, response)
 }
 
 func main() {
-	// 加载环境变量
-	if err := godotenv.Load(".env"); err != nil {
-		log.Printf("Warning: .env file not found: %v", err)
-	}
+	// 加载环境变量，日志初始化后再提示
+	envErr := godotenv.Load(".env")
 
 	// 初始化配置
 	config = Config{
-		QwenVLAPIKey:   getEnv("QWEN_VL_API_KEY", ""),
-		QwenTextAPIKey: getEnv("QWEN_TEXT_API_KEY", ""),
 		OpenAIAPIKey:   getEnv("OPENAI_API_KEY", ""),
+		Offline:        getEnv("OFFLINE_MODE", "false") == "true",
+		OllamaBaseURL:  strings.TrimRight(getEnv("OLLAMA_BASE_URL", "http://localhost:11434/api"), "/"),
+		EmbeddingModel: getEnv("EMBEDDING_MODEL", "nomic-embed-text"),
 		DBPath:         getEnv("DB_PATH", "./instago.db"),
+		BlobDir:        getEnv("BLOB_DIR", "./blobs"),
 		Port:           getEnv("PORT", "19200"),
+		JobWorkers:     getEnvInt("JOB_WORKERS", 2),
+		JobQueueSize:   getEnvInt("JOB_QUEUE_SIZE", 100),
+		InboxFolderID:  getEnvInt("INBOX_FOLDER_ID", 0),
+
+		IndexRetryInterval: getEnvInt("INDEX_RETRY_INTERVAL", 60),
+		IndexMaxAttempts:   getEnvInt("INDEX_MAX_ATTEMPTS", 10),
+
+		ReclassifyBatchSize: getEnvInt("RECLASSIFY_BATCH_SIZE", 50),
+
+		CORS: CORSConfig{
+			AllowedOrigins:   splitList(getEnv("CORS_ALLOWED_ORIGINS", "")),
+			AllowedMethods:   splitList(getEnv("CORS_ALLOWED_METHODS", "GET,POST,PATCH,DELETE")),
+			AllowedHeaders:   splitList(getEnv("CORS_ALLOWED_HEADERS", "Content-Type,Authorization")),
+			AllowCredentials: getEnv("CORS_ALLOW_CREDENTIALS", "true") == "true",
+			MaxAge:           getEnvInt("CORS_MAX_AGE", 600),
+		},
+
+		Log: LogConfig{
+			Level:   getEnv("LOG_LEVEL", "info"),
+			Format:  getEnv("LOG_FORMAT", "text"),
+			Content: getEnv("LOG_CONTENT", "false") == "true",
+		},
+	}
+	if err := initLogger(config.Log); err != nil {
+		fatal("Failed to initialize logger", "err", err)
+	}
+	if envErr != nil {
+		slog.Warn(".env file not found", "err", envErr)
+	}
+	config.Vision = loadModelConfig("VISION", getEnv("QWEN_VL_API_KEY", ""))
+	config.Text = loadModelConfig("TEXT", getEnv("QWEN_TEXT_API_KEY", ""))
+
+	// 命令行子命令
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 初始化模型提供方
+	if err := initModels(); err != nil {
+		fatal("Failed to initialize models", "err", err)
 	}
 
 	// 初始化数据库
 	if err := initDB(); err != nil {
-		log.Fatal("Failed to initialize database:", err)
+		fatal("Failed to initialize database", "err", err)
 	}
 	defer db.Close()
 
+	// 收件箱文件夹必须存在，否则无效推荐的对象又会成为孤立对象
+	exists, err := folderExists(DefaultUserID, config.InboxFolderID)
+	if err != nil {
+		fatal("Failed to check inbox folder", "err", err)
+	}
+	if !exists {
+		fatal("INBOX_FOLDER_ID 对应的文件夹不存在", "folder_id", config.InboxFolderID)
+	}
+
+	// 没有令牌时所有接口都无法访问，提示先创建
+	tokens, err := listAPITokens()
+	if err != nil {
+		fatal("Failed to load API tokens", "err", err)
+	}
+	if len(tokens) == 0 {
+		slog.Warn("尚未创建接口令牌，请先运行 instago token create <名称> 创建管理员令牌")
+	}
+
+	// 初始化全文索引
+	if err := initFTS(); err != nil {
+		fatal("Failed to initialize full-text index", "err", err)
+	}
+
 	// 初始化向量数据库
 	if err := initVectorDB(); err != nil {
-		log.Fatal("Failed to initialize vector database:", err)
+		fatal("Failed to initialize vector database", "err", err)
 	}
 
-	// 设置路由
-	router := gin.Default()
+	// 启动上传任务工作池
+	if err := startJobWorkers(config.JobWorkers, config.JobQueueSize); err != nil {
+		fatal("Failed to start job workers", "err", err)
+	}
+	startIndexRetryWorker(time.Duration(config.IndexRetryInterval)*time.Second, config.IndexMaxAttempts)
 
-	// 添加CORS中间件
-	router.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Content-Type, Authorization")
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
-		c.Next()
-	})
+	// 设置路由，访问日志由 requestLogger 记录，不使用 gin 自带的日志（会输出查询参数中的令牌）
+	router := gin.New()
+	router.Use(requestLogger(), gin.Recovery())
+
+	// 添加CORS中间件，只允许配置的来源
+	router.Use(corsMiddleware(config.CORS))
 
-	// 静态文件服务
-	router.Static("/static", "./")
-	router.StaticFile("/", "./frontend.html")
-	router.StaticFile("/frontend.html", "./frontend.html")
-	router.StaticFile("/test.html", "./test.html")
+	// 前端页面
+	registerStaticRoutes(router)
 
 	// 健康检查
 	router.GET("/ping", func(c *gin.Context) {
 		c.JSON(200, gin.H{"message": "pong"})
 	})
 
+	// 以下接口都需要令牌，按权限分组
+	readAPI := router.Group("", requireScope(ScopeRead))
+	writeAPI := router.Group("", requireScope(ScopeWrite))
+	adminAPI := router.Group("/admin", requireScope(ScopeAdmin))
+
 	// 主要接口
-	router.POST("/upload", uploadHandler)
-	router.POST("/search", searchHandler)
+	writeAPI.POST("/upload", uploadHandler)
+	readAPI.POST("/search", searchHandler)
+	readAPI.GET("/jobs/:id", getJobHandler)
 
-	// 文件夹管理接口
-	router.POST("/folder", createOrUpdateFolderHandler)
-	router.GET("/folder/:id", getFolderContentsHandler)
-	router.DELETE("/folder/:id", deleteFolderHandler)
+	// 对象接口
+	readAPI.GET("/objects/:id", getObjectHandler)
+	writeAPI.PATCH("/objects/:id", updateObjectHandler)
+	writeAPI.DELETE("/objects/:id", deleteObjectHandler)
+
+	// 图片接口
+	readAPI.GET("/objects/:id/image", getObjectImageHandler)
+	readAPI.GET("/objects/:id/thumbnail", getObjectThumbnailHandler)
 
-	log.Printf("Server starting on port %s", config.Port)
-	router.Run(":" + config.Port)
+	// 文件夹管理接口
+	writeAPI.POST("/folder", createOrUpdateFolderHandler)
+	readAPI.GET("/folder/:id", getFolderContentsHandler)
+	writeAPI.DELETE("/folder/:id", deleteFolderHandler)
+	readAPI.GET("/folders/tree", getFolderTreeHandler)
+
+	// 向量库一致性检查和重建
+	adminAPI.GET("/index/verify", verifyIndexHandler)
+	adminAPI.POST("/index/reindex", reindexHandler)
+
+	// 用户接口
+	readAPI.GET("/me", getCurrentUserHandler)
+	adminAPI.GET("/users", listUsersHandler)
+	adminAPI.POST("/users", createUserHandler)
+
+	// 令牌管理接口
+	adminAPI.GET("/tokens", listAPITokensHandler)
+	adminAPI.POST("/tokens", createAPITokenHandler)
+	adminAPI.DELETE("/tokens/:id", deleteAPITokenHandler)
+
+	// 分类规则接口
+	readAPI.GET("/rules", listFolderRulesHandler)
+	writeAPI.POST("/rules", createFolderRuleHandler)
+	writeAPI.DELETE("/rules/:id", deleteFolderRuleHandler)
+
+	// 重新分类接口
+	writeAPI.POST("/reclassify", reclassifyHandler)
+	writeAPI.POST("/reclassify/apply", applyReclassifyHandler)
+
+	slog.Info("Server starting", "port", config.Port)
+	if err := router.Run(":" + config.Port); err != nil {
+		fatal("Server stopped", "err", err)
+	}
 }
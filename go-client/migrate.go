@@ -0,0 +1,311 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// 数据库迁移：按版本号顺序执行，已执行的版本记录在 schema_migrations 表中。
+// 新的表结构修改只能追加新的迁移，不能修改已发布的迁移。
+//
+// 引入迁移之前的安装已经通过启动时的 ALTER TABLE 加过部分列，
+// 所以加列前先检查列是否存在，第一次迁移时这些版本会直接记为已执行。
+
+type migration struct {
+	version int
+	name    string
+	up      func(tx *sql.Tx) error
+}
+
+var migrations = []migration{
+	{1, "create folders and objects", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS folders (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			upper INTEGER DEFAULT 0
+		)`, `
+		CREATE TABLE IF NOT EXISTS objects (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			data TEXT NOT NULL,
+			description TEXT NOT NULL,
+			folder_id INTEGER DEFAULT 0
+		)`)
+	}},
+	{2, "add object name and possible_from", func(tx *sql.Tx) error {
+		return addColumns(tx, "objects",
+			"name TEXT NOT NULL DEFAULT ''",
+			"possible_from TEXT")
+	}},
+	{3, "create jobs", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS jobs (
+			id TEXT PRIMARY KEY,
+			status TEXT NOT NULL,
+			error TEXT NOT NULL DEFAULT '',
+			payload TEXT NOT NULL DEFAULT '',
+			object_id INTEGER NOT NULL DEFAULT 0,
+			result TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	}},
+	// 图片改为存储在磁盘blob中，objects只保留哈希、大小和类型，数据搬迁见 migrateObjectBlobs
+	{4, "add object blob columns", func(tx *sql.Tx) error {
+		return addColumns(tx, "objects",
+			"blob_hash TEXT NOT NULL DEFAULT ''",
+			"blob_size INTEGER NOT NULL DEFAULT 0",
+			"mime_type TEXT NOT NULL DEFAULT ''")
+	}},
+	{5, "add screenshot metadata", func(tx *sql.Tx) error {
+		err := addColumns(tx, "objects",
+			"screenshot_timestamp INTEGER NOT NULL DEFAULT 0",
+			"screenshot_app_name TEXT NOT NULL DEFAULT ''",
+			"screenshot_tags TEXT NOT NULL DEFAULT ''",
+			// SQLite 不允许 ADD COLUMN 使用 CURRENT_TIMESTAMP 默认值，由插入语句显式赋值
+			"created_at DATETIME")
+		if err != nil {
+			return err
+		}
+		// 旧数据没有创建时间，以升级的时间代替
+		return execAll(tx, "UPDATE objects SET created_at = CURRENT_TIMESTAMP WHERE created_at IS NULL")
+	}},
+	{6, "create object indexes", func(tx *sql.Tx) error {
+		return execAll(tx,
+			"CREATE INDEX IF NOT EXISTS idx_objects_folder_id ON objects(folder_id)",
+			"CREATE INDEX IF NOT EXISTS idx_objects_screenshot_timestamp ON objects(screenshot_timestamp)",
+			"CREATE INDEX IF NOT EXISTS idx_objects_screenshot_app_name ON objects(screenshot_app_name COLLATE NOCASE)")
+	}},
+	{7, "add folder description and rules", func(tx *sql.Tx) error {
+		if err := addColumns(tx, "folders", "description TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS folder_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			folder_id INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			pattern TEXT NOT NULL,
+			priority INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`)
+	}},
+	{8, "add object classification audit", func(tx *sql.Tx) error {
+		return addColumns(tx, "objects",
+			"suggested_folder_id TEXT NOT NULL DEFAULT ''",
+			"folder_confidence REAL NOT NULL DEFAULT 0",
+			"folder_source TEXT NOT NULL DEFAULT ''")
+	}},
+	// 已有对象在引入索引状态之前都已索引过
+	{9, "add object index state", func(tx *sql.Tx) error {
+		err := addColumns(tx, "objects",
+			"index_state TEXT NOT NULL DEFAULT 'indexed'",
+			"index_error TEXT NOT NULL DEFAULT ''",
+			"index_attempts INTEGER NOT NULL DEFAULT 0",
+			"index_next_attempt_at DATETIME",
+			"search_content TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		return execAll(tx, "CREATE INDEX IF NOT EXISTS idx_objects_index_state ON objects(index_state)")
+	}},
+	// 文本模型生成的搜索内容改为按列保存，取代 search_content 中的JSON
+	{10, "persist object search content", func(tx *sql.Tx) error {
+		err := addColumns(tx, "objects",
+			"digest TEXT NOT NULL DEFAULT ''",
+			"keywords TEXT NOT NULL DEFAULT ''",
+			"questions TEXT NOT NULL DEFAULT '[]'", // JSON数组
+			"scenario TEXT NOT NULL DEFAULT ''",
+			"from_site TEXT NOT NULL DEFAULT ''",
+			"origin_content TEXT NOT NULL DEFAULT ''")
+		if err != nil {
+			return err
+		}
+		return execAll(tx, `
+		UPDATE objects SET
+			digest = COALESCE(json_extract(search_content, '$.digest'), ''),
+			keywords = COALESCE(json_extract(search_content, '$.keywords'), ''),
+			questions = COALESCE(json_extract(search_content, '$.questions'), '[]'),
+			scenario = COALESCE(json_extract(search_content, '$.scenario'), ''),
+			from_site = COALESCE(json_extract(search_content, '$.from_site'), ''),
+			origin_content = COALESCE(json_extract(search_content, '$.origin_content'), '')
+		WHERE search_content != ''`,
+			"ALTER TABLE objects DROP COLUMN search_content")
+	}},
+	{11, "create api tokens", func(tx *sql.Tx) error {
+		return execAll(tx, `
+		CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL, -- 逗号分隔
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			last_used_at DATETIME
+		)`)
+	}},
+	// 已有的文件夹、对象、任务和令牌都归默认用户，根文件夹仍是 0
+	{12, "add users", func(tx *sql.Tx) error {
+		err := execAll(tx, `
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			root_folder_id INTEGER NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+			"INSERT OR IGNORE INTO users (id, name, root_folder_id) VALUES (1, 'default', 0)")
+		if err != nil {
+			return err
+		}
+		for _, table := range []string{"folders", "objects", "jobs", "api_tokens"} {
+			if err := addColumns(tx, table, "user_id INTEGER NOT NULL DEFAULT 1"); err != nil {
+				return err
+			}
+		}
+		return execAll(tx,
+			"INSERT OR IGNORE INTO folders (id, name, upper, user_id) VALUES (0, 'Root', 0, 1)",
+			"CREATE INDEX IF NOT EXISTS idx_folders_user_id ON folders(user_id, upper)",
+			"CREATE INDEX IF NOT EXISTS idx_objects_user_id ON objects(user_id, folder_id)")
+	}},
+	// 任务引用的图片单独成列，删除图片前按列检查引用
+	{13, "add job blob hash", func(tx *sql.Tx) error {
+		if err := addColumns(tx, "jobs", "blob_hash TEXT NOT NULL DEFAULT ''"); err != nil {
+			return err
+		}
+		return execAll(tx,
+			"UPDATE jobs SET blob_hash = COALESCE(json_extract(payload, '$.blob.hash'), '') WHERE payload != ''",
+			"CREATE INDEX IF NOT EXISTS idx_jobs_blob_hash ON jobs(blob_hash)",
+			"CREATE INDEX IF NOT EXISTS idx_objects_blob_hash ON objects(blob_hash)")
+	}},
+}
+
+// 迁移状态
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt *time.Time // 未执行时为 nil
+}
+
+// 执行所有未执行的迁移，返回本次执行的数量
+func runMigrations() (int, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return 0, err
+	}
+
+	// 数据库由更新的版本迁移过时拒绝启动，避免旧程序写坏新结构
+	latest := migrations[len(migrations)-1].version
+	for version := range applied {
+		if version > latest {
+			return 0, fmt.Errorf("数据库结构版本 %d 高于程序支持的版本 %d，请升级程序", version, latest)
+		}
+	}
+
+	count := 0
+	for _, m := range migrations {
+		if _, ok := applied[m.version]; ok {
+			continue
+		}
+		if err := applyMigration(m); err != nil {
+			return count, fmt.Errorf("迁移 %d (%s) 失败: %v", m.version, m.name, err)
+		}
+		slog.Info("已执行数据库迁移", "version", m.version, "name", m.name)
+		count++
+	}
+	return count, nil
+}
+
+// 在一个事务中执行迁移并记录版本
+func applyMigration(m migration) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := m.up(tx); err != nil {
+		return err
+	}
+	if _, err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.version, m.name); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// 读取已执行的迁移版本及执行时间
+func appliedMigrations() (map[int]time.Time, error) {
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// 列出所有迁移及其执行情况
+func migrationStatus() ([]MigrationStatus, error) {
+	applied, err := appliedMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var statuses []MigrationStatus
+	for _, m := range migrations {
+		status := MigrationStatus{Version: m.version, Name: m.name}
+		if appliedAt, ok := applied[m.version]; ok {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func execAll(tx *sql.Tx, stmts ...string) error {
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 添加表中还不存在的列，definition 以列名开头
+func addColumns(tx *sql.Tx, table string, definitions ...string) error {
+	for _, definition := range definitions {
+		var column string
+		fmt.Sscan(definition, &column)
+
+		var count int
+		if err := tx.QueryRow("SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?", table, column).Scan(&count); err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", table, definition)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
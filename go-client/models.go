@@ -0,0 +1,494 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 视觉模型：根据提示词描述一张截图
+type VisionModel interface {
+	DescribeImage(ctx context.Context, prompt string, imageBase64 string) (string, error)
+}
+
+// 文本模型：根据提示词生成文本
+type TextModel interface {
+	Generate(ctx context.Context, prompt string) (string, error)
+}
+
+// 支持约束JSON输出的文本模型（如 Ollama 的 format: json）
+type JSONTextModel interface {
+	GenerateJSON(ctx context.Context, prompt string) (string, error)
+}
+
+// 生成JSON，模型支持时使用其JSON输出模式
+func generateJSON(ctx context.Context, m TextModel, prompt string) (string, error) {
+	if jm, ok := m.(JSONTextModel); ok {
+		return jm.GenerateJSON(ctx, prompt)
+	}
+	return m.Generate(ctx, prompt)
+}
+
+// 模型提供方配置
+type ModelConfig struct {
+	Provider string // dashscope | openai | ollama
+	Model    string
+	BaseURL  string
+	APIKey   string
+}
+
+const (
+	ProviderDashScope = "dashscope"
+	ProviderOpenAI    = "openai"
+	ProviderOllama    = "ollama"
+)
+
+// 各提供方的默认地址
+var defaultBaseURLs = map[string]string{
+	ProviderDashScope: "https://dashscope.aliyuncs.com/api/v1",
+	ProviderOpenAI:    "https://api.openai.com/v1",
+	ProviderOllama:    "http://localhost:11434/api",
+}
+
+// 各提供方的默认视觉模型
+var defaultVisionModels = map[string]string{
+	ProviderDashScope: "qwen-vl-plus",
+	ProviderOpenAI:    "gpt-4o-mini",
+	ProviderOllama:    "llava",
+}
+
+// 各提供方的默认文本模型
+var defaultTextModels = map[string]string{
+	ProviderDashScope: "qwen-turbo",
+	ProviderOpenAI:    "gpt-4o-mini",
+	ProviderOllama:    "qwen2.5",
+}
+
+// 全局模型实例
+var (
+	visionModel VisionModel
+	textModel   TextModel
+)
+
+// 从环境变量读取模型配置，prefix 为 VISION 或 TEXT
+func loadModelConfig(prefix, legacyDashScopeKey string) ModelConfig {
+	// 离线模式下默认全部使用本地 Ollama
+	defaultProvider := ProviderDashScope
+	if config.Offline {
+		defaultProvider = ProviderOllama
+	}
+
+	cfg := ModelConfig{
+		Provider: strings.ToLower(getEnv(prefix+"_PROVIDER", defaultProvider)),
+		Model:    getEnv(prefix+"_MODEL", ""),
+		BaseURL:  getEnv(prefix+"_BASE_URL", ""),
+		APIKey:   getEnv(prefix+"_API_KEY", ""),
+	}
+
+	// 兼容旧的 QWEN_*_API_KEY / OPENAI_API_KEY 配置
+	if cfg.APIKey == "" {
+		switch cfg.Provider {
+		case ProviderDashScope:
+			cfg.APIKey = legacyDashScopeKey
+		case ProviderOpenAI:
+			cfg.APIKey = config.OpenAIAPIKey
+		}
+	}
+	return cfg
+}
+
+// 补全默认的模型名和地址
+func (cfg ModelConfig) withDefaults(defaultModels map[string]string) (ModelConfig, error) {
+	if _, ok := defaultBaseURLs[cfg.Provider]; !ok {
+		return cfg, fmt.Errorf("不支持的模型提供方: %q", cfg.Provider)
+	}
+	if cfg.Model == "" {
+		cfg.Model = defaultModels[cfg.Provider]
+	}
+	if cfg.BaseURL == "" && cfg.Provider == ProviderOllama {
+		cfg.BaseURL = config.OllamaBaseURL
+	}
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = defaultBaseURLs[cfg.Provider]
+	}
+	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
+	return cfg, nil
+}
+
+// 根据配置创建视觉模型
+func newVisionModel(cfg ModelConfig) (VisionModel, error) {
+	cfg, err := cfg.withDefaults(defaultVisionModels)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Provider {
+	case ProviderDashScope:
+		// 未配置密钥时使用模拟模式，便于本地调试
+		if cfg.APIKey == "" {
+			return mockVisionModel{}, nil
+		}
+		return &dashScopeModel{cfg: cfg}, nil
+	case ProviderOpenAI:
+		return &openAIModel{cfg: cfg}, nil
+	default:
+		return &ollamaModel{cfg: cfg}, nil
+	}
+}
+
+// 根据配置创建文本模型
+func newTextModel(cfg ModelConfig) (TextModel, error) {
+	cfg, err := cfg.withDefaults(defaultTextModels)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Provider {
+	case ProviderDashScope:
+		return &dashScopeModel{cfg: cfg}, nil
+	case ProviderOpenAI:
+		return &openAIModel{cfg: cfg}, nil
+	default:
+		return &ollamaModel{cfg: cfg}, nil
+	}
+}
+
+// 初始化视觉和文本模型
+func initModels() error {
+	// 离线模式不允许任何远程模型，避免截图内容离开本机
+	if config.Offline {
+		if config.Vision.Provider != ProviderOllama || config.Text.Provider != ProviderOllama {
+			return fmt.Errorf("离线模式下视觉和文本模型都必须使用 %s", ProviderOllama)
+		}
+	}
+
+	var err error
+	visionModel, err = newVisionModel(config.Vision)
+	if err != nil {
+		return fmt.Errorf("初始化视觉模型失败: %v", err)
+	}
+
+	textModel, err = newTextModel(config.Text)
+	if err != nil {
+		return fmt.Errorf("初始化文本模型失败: %v", err)
+	}
+
+	if config.Offline {
+		vision, _ := config.Vision.withDefaults(defaultVisionModels)
+		text, _ := config.Text.withDefaults(defaultTextModels)
+		checkOllamaModels(vision.Model, text.Model, config.EmbeddingModel)
+	}
+	return nil
+}
+
+// 检查本地 Ollama 是否已拉取所需模型，缺失时仅打印警告
+func checkOllamaModels(models ...string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", config.OllamaBaseURL+"/tags", nil)
+	if err != nil {
+		return
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		slog.Warn("Ollama 服务不可用", "url", config.OllamaBaseURL, "err", err)
+		return
+	}
+	defer resp.Body.Close()
+
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		slog.Warn("无法解析 Ollama 模型列表", "err", err)
+		return
+	}
+
+	installed := make(map[string]bool)
+	for _, m := range tags.Models {
+		installed[m.Name] = true
+		// "llava:latest" 也可以用 "llava" 引用
+		installed[strings.TrimSuffix(m.Name, ":latest")] = true
+	}
+	for _, model := range models {
+		if !installed[model] {
+			slog.Warn("Ollama 模型未安装，请先执行 ollama pull", "model", model)
+		}
+	}
+}
+
+// 发送JSON请求并返回响应体。响应中包含截图内容，日志只记录状态和长度
+func postJSON(ctx context.Context, url, apiKey string, payload interface{}) ([]byte, error) {
+	jsonData, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	start := time.Now()
+	client := &http.Client{}
+	resp, err := client.Do(req)
+	if err != nil {
+		slog.WarnContext(ctx, "模型请求失败", "url", url, "err", err)
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		slog.WarnContext(ctx, "读取模型响应失败", "url", url, "status", resp.StatusCode, "err", err)
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		slog.WarnContext(ctx, "模型接口返回错误", "url", url, "status", resp.StatusCode,
+			"duration_ms", time.Since(start).Milliseconds(), "body", string(body))
+		return nil, fmt.Errorf("model API returned %s", resp.Status)
+	}
+	slog.DebugContext(ctx, "模型请求完成", "url", url, "status", resp.StatusCode,
+		"duration_ms", time.Since(start).Milliseconds(), "response_bytes", len(body))
+	return body, nil
+}
+
+// 模拟视觉模型，未配置密钥时使用
+type mockVisionModel struct{}
+
+func (mockVisionModel) DescribeImage(ctx context.Context, prompt string, imageBase64 string) (string, error) {
+	return "Mock description: This is a sample image description for testing purposes.", nil
+}
+
+// 千问API响应结构
+type QwenVLResponse struct {
+	Output struct {
+		Choices []struct {
+			Message struct {
+				Content []struct {
+					Text string `json:"text"`
+				} `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	} `json:"output"`
+}
+
+type QwenTextResponse struct {
+	Output struct {
+		Text string `json:"text"`
+	} `json:"output"`
+}
+
+// 阿里云百炼 DashScope
+type dashScopeModel struct {
+	cfg ModelConfig
+}
+
+func (m *dashScopeModel) DescribeImage(ctx context.Context, prompt string, imageBase64 string) (string, error) {
+	requestBody := map[string]interface{}{
+		"model": m.cfg.Model,
+		"input": map[string]interface{}{
+			"messages": []map[string]interface{}{
+				{
+					"role": "user",
+					"content": []map[string]interface{}{
+						{
+							"image": fmt.Sprintf("data:image/jpeg;base64,%s", imageBase64),
+						},
+						{
+							"text": prompt,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	body, err := postJSON(ctx, m.cfg.BaseURL+"/services/aigc/multimodal-generation/generation", m.cfg.APIKey, requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	var response QwenVLResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("invalid response from %s: %v", m.cfg.Model, err)
+	}
+
+	if len(response.Output.Choices) == 0 {
+		return "", fmt.Errorf("no response from %s", m.cfg.Model)
+	}
+
+	// 提取文本内容
+	content := response.Output.Choices[0].Message.Content
+	if len(content) == 0 {
+		return "", fmt.Errorf("no content in response")
+	}
+
+	return content[0].Text, nil
+}
+
+func (m *dashScopeModel) Generate(ctx context.Context, prompt string) (string, error) {
+	if m.cfg.APIKey == "" {
+		return "", fmt.Errorf("DashScope API key is not set")
+	}
+
+	requestBody := map[string]interface{}{
+		"model": m.cfg.Model,
+		"input": map[string]interface{}{
+			"messages": []map[string]interface{}{
+				{
+					"role":    "user",
+					"content": prompt,
+				},
+			},
+		},
+	}
+
+	body, err := postJSON(ctx, m.cfg.BaseURL+"/services/aigc/text-generation/generation", m.cfg.APIKey, requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	var response QwenTextResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("invalid response from %s: %v", m.cfg.Model, err)
+	}
+
+	if response.Output.Text == "" {
+		return "", fmt.Errorf("no response from %s", m.cfg.Model)
+	}
+	return response.Output.Text, nil
+}
+
+// OpenAI 兼容接口（OpenAI、vLLM、LM Studio、DashScope兼容模式等）
+type openAIModel struct {
+	cfg ModelConfig
+}
+
+type openAIChatResponse struct {
+	Choices []struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+func (m *openAIModel) chat(ctx context.Context, content interface{}) (string, error) {
+	requestBody := map[string]interface{}{
+		"model": m.cfg.Model,
+		"messages": []map[string]interface{}{
+			{
+				"role":    "user",
+				"content": content,
+			},
+		},
+	}
+
+	body, err := postJSON(ctx, m.cfg.BaseURL+"/chat/completions", m.cfg.APIKey, requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	var response openAIChatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("invalid response from %s: %v", m.cfg.Model, err)
+	}
+
+	if len(response.Choices) == 0 || response.Choices[0].Message.Content == "" {
+		return "", fmt.Errorf("no response from %s", m.cfg.Model)
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+func (m *openAIModel) DescribeImage(ctx context.Context, prompt string, imageBase64 string) (string, error) {
+	return m.chat(ctx, []map[string]interface{}{
+		{
+			"type": "image_url",
+			"image_url": map[string]string{
+				"url": fmt.Sprintf("data:image/jpeg;base64,%s", imageBase64),
+			},
+		},
+		{
+			"type": "text",
+			"text": prompt,
+		},
+	})
+}
+
+func (m *openAIModel) Generate(ctx context.Context, prompt string) (string, error) {
+	return m.chat(ctx, prompt)
+}
+
+// 本地 Ollama
+type ollamaModel struct {
+	cfg ModelConfig
+}
+
+type ollamaChatResponse struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+func (m *ollamaModel) chat(ctx context.Context, message map[string]interface{}, format string) (string, error) {
+	requestBody := map[string]interface{}{
+		"model":    m.cfg.Model,
+		"messages": []map[string]interface{}{message},
+		"stream":   false,
+	}
+	if format != "" {
+		requestBody["format"] = format
+	}
+
+	body, err := postJSON(ctx, m.cfg.BaseURL+"/chat", m.cfg.APIKey, requestBody)
+	if err != nil {
+		return "", err
+	}
+
+	var response ollamaChatResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", fmt.Errorf("invalid response from %s: %v", m.cfg.Model, err)
+	}
+
+	if response.Message.Content == "" {
+		return "", fmt.Errorf("no response from %s", m.cfg.Model)
+	}
+	return response.Message.Content, nil
+}
+
+func (m *ollamaModel) DescribeImage(ctx context.Context, prompt string, imageBase64 string) (string, error) {
+	return m.chat(ctx, map[string]interface{}{
+		"role":    "user",
+		"content": prompt,
+		"images":  []string{imageBase64},
+	}, "")
+}
+
+func (m *ollamaModel) Generate(ctx context.Context, prompt string) (string, error) {
+	return m.chat(ctx, map[string]interface{}{
+		"role":    "user",
+		"content": prompt,
+	}, "")
+}
+
+func (m *ollamaModel) GenerateJSON(ctx context.Context, prompt string) (string, error) {
+	return m.chat(ctx, map[string]interface{}{
+		"role":    "user",
+		"content": prompt,
+	}, "json")
+}
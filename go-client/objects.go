@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 根据路径参数加载对象，失败时直接写入错误响应
+func loadObject(c *gin.Context) (Object, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid object ID"})
+		return Object{}, false
+	}
+
+	// 其他用户的对象与不存在的对象一样返回404
+	obj, err := getObjectByID(id)
+	if err == sql.ErrNoRows || (err == nil && obj.UserID != currentUserID(c)) {
+		c.JSON(404, gin.H{"error": "Object not found"})
+		return Object{}, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get object: %v", err)})
+		return Object{}, false
+	}
+	return obj, true
+}
+
+// 获取对象处理器
+func getObjectHandler(c *gin.Context) {
+	obj, ok := loadObject(c)
+	if !ok {
+		return
+	}
+	c.JSON(200, obj)
+}
+
+// 重命名、移动对象或修改搜索内容处理器
+func updateObjectHandler(c *gin.Context) {
+	obj, ok := loadObject(c)
+	if !ok {
+		return
+	}
+
+	var req ObjectUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Name == nil && req.FolderID == nil && req.Digest == nil && req.Keywords == nil &&
+		req.Questions == nil && req.Scenario == nil {
+		c.JSON(400, gin.H{"error": "Nothing to update"})
+		return
+	}
+
+	renamed := false
+	if req.Name != nil {
+		name := strings.TrimSpace(*req.Name)
+		if name == "" {
+			c.JSON(400, gin.H{"error": "Object name cannot be empty"})
+			return
+		}
+		renamed = name != obj.Name
+		obj.Name = name
+	}
+	if req.FolderID != nil {
+		exists, err := folderExists(obj.UserID, *req.FolderID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folder: %v", err)})
+			return
+		}
+		if !exists {
+			c.JSON(400, gin.H{"error": "Folder not found"})
+			return
+		}
+		obj.FolderID = *req.FolderID
+	}
+
+	// 修改搜索内容后需要重新生成向量
+	contentChanged := false
+	if req.Digest != nil {
+		digest := strings.TrimSpace(*req.Digest)
+		contentChanged = contentChanged || digest != obj.Digest
+		obj.Digest = digest
+	}
+	if req.Keywords != nil {
+		keywords := strings.TrimSpace(*req.Keywords)
+		contentChanged = contentChanged || keywords != obj.Keywords
+		obj.Keywords = keywords
+	}
+	if req.Questions != nil {
+		questions := []string{}
+		for _, question := range *req.Questions {
+			if question = strings.TrimSpace(question); question != "" {
+				questions = append(questions, question)
+			}
+		}
+		contentChanged = contentChanged || questionsJSON(questions) != questionsJSON(obj.Questions)
+		obj.Questions = questions
+	}
+	if req.Scenario != nil {
+		scenario := strings.TrimSpace(*req.Scenario)
+		contentChanged = contentChanged || scenario != obj.Scenario
+		obj.Scenario = scenario
+	}
+
+	if err := updateObject(obj); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to update object: %v", err)})
+		return
+	}
+
+	// 内容修改后重新写入全部索引，失败时对象已保存，由后台重试
+	if contentChanged {
+		if err := indexObject(obj); err != nil {
+			slog.WarnContext(c.Request.Context(), "重新索引失败，稍后重试", "object_id", obj.ID, "err", err)
+		}
+		// 返回最新的索引状态
+		if updated, err := getObjectByID(obj.ID); err == nil {
+			obj = updated
+		}
+		c.JSON(200, obj)
+		return
+	}
+
+	// 重命名后同步全文索引；向量文档不记录文件夹，移动不需要修改向量库
+	if renamed {
+		if err := indexObjectText(obj); err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to update full-text index: %v", err)})
+			return
+		}
+	}
+
+	c.JSON(200, obj)
+}
+
+// 删除对象处理器
+func deleteObjectHandler(c *gin.Context) {
+	obj, ok := loadObject(c)
+	if !ok {
+		return
+	}
+
+	if err := deleteObject(obj); err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to delete object: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Object deleted successfully"})
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 重新分类：文件夹结构调整后，用已保存的描述和当前的文件夹树重新推荐文件夹，
+// 不重新调用视觉模型。默认只返回建议，确认后再移动。
+
+// 单个对象的分类建议
+type ReclassifyProposal struct {
+	ObjectID         int     `json:"object_id"`
+	Name             string  `json:"name"`
+	CurrentFolderID  int     `json:"current_folder_id"`
+	ProposedFolderID int     `json:"proposed_folder_id"`
+	Confidence       float64 `json:"confidence"`
+	RuleID           int     `json:"rule_id,omitempty"` // 由分类规则决定时的规则ID
+	Changed          bool    `json:"changed"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// 根据描述和文件夹树推荐文件夹
+func recommendFolder(ctx context.Context, obj Object, folderTree string) (int, float64, error) {
+	prompt := fmt.Sprintf(`
+根据以下图片的标题、描述和文件夹结构，推荐最合适的存储文件夹ID。
+注意：你应该详细分析文件夹结构，比如一个父级文件夹可能指向“品牌”，“类别”，子文件夹是更具体的信息，一个可能的场景比如：
+ "算法\n\t力扣\n\t洛谷"，这个说明在算法文件夹下存在着力扣、洛谷两个子文件夹，如果一个截图内容中包含“力扣”、“leetcode”字样，你应该将其放在力扣文件夹下。
+文件夹名后“：”之后的内容是用户写的文件夹说明，优先按说明判断文件夹的用途。
+只返回文件夹ID和你对这个推荐的置信度（0到1之间），不要返回其他内容。
+
+图片标题：
+%s
+
+图片描述：
+%s
+
+文件夹结构：
+%s
+
+请以JSON格式回复：
+{
+  "folder_id": 推荐的文件夹ID,
+  "confidence": 0.8
+}
+`, obj.Name, obj.Description, folderTree)
+
+	var folderID int
+	var confidence float64
+	err := generateStructured(ctx, prompt, func(content string) error {
+		fields, err := decodeJSONObject(content)
+		if err != nil {
+			return err
+		}
+		raw := jsonRaw(fields["folder_id"])
+		var ok bool
+		if folderID, ok = parseFolderID(json.RawMessage(raw)); !ok {
+			return fmt.Errorf("folder_id 应为整数，实际为 %s", raw)
+		}
+		confidence = jsonConfidence(fields["confidence"])
+		return nil
+	})
+	return folderID, confidence, err
+}
+
+// 为用户的一组对象生成分类建议
+func proposeReclassification(ctx context.Context, userID int, objects []Object) ([]ReclassifyProposal, error) {
+	folderTree, err := getFolderTree(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	proposals := []ReclassifyProposal{}
+	for _, obj := range objects {
+		proposal := ReclassifyProposal{
+			ObjectID:         obj.ID,
+			Name:             obj.Name,
+			CurrentFolderID:  obj.FolderID,
+			ProposedFolderID: obj.FolderID,
+		}
+
+		// 分类规则优先，命中时不调用模型
+		rule, err := matchFolderRule(userID, obj.ScreenshotAppName, obj.ScreenshotTags, obj.Description)
+		if err != nil {
+			return nil, err
+		}
+		if rule != nil {
+			proposal.ProposedFolderID = rule.FolderID
+			proposal.Confidence = 1
+			proposal.RuleID = rule.ID
+			proposal.Changed = rule.FolderID != obj.FolderID
+			proposals = append(proposals, proposal)
+			continue
+		}
+
+		folderID, confidence, err := recommendFolder(ctx, obj, folderTree)
+		if err != nil {
+			proposal.Error = err.Error()
+			proposals = append(proposals, proposal)
+			continue
+		}
+
+		exists, err := folderExists(userID, folderID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			proposal.Error = fmt.Sprintf("推荐的文件夹 %d 不存在", folderID)
+			proposals = append(proposals, proposal)
+			continue
+		}
+
+		proposal.ProposedFolderID = folderID
+		proposal.Confidence = confidence
+		proposal.Changed = folderID != obj.FolderID
+		proposals = append(proposals, proposal)
+	}
+	return proposals, nil
+}
+
+// 按ID顺序取下一批对象；还有剩余时返回本批最后一个对象的ID，作为下一批的 after_id
+func nextReclassifyBatch(filter ObjectFilter, limit int) ([]Object, *int, error) {
+	filter.Sort, filter.Order = "id", "asc"
+	where, args := filter.where()
+	// 多取一个用于判断是否还有剩余
+	args = append(args, limit+1)
+	rows, err := db.Query("SELECT "+objectColumns+" FROM objects WHERE 1 = 1"+where+filter.orderBy()+" LIMIT ?", args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var objects []Object
+	for rows.Next() {
+		obj, err := scanObject(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, obj)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if len(objects) <= limit {
+		return objects, nil, nil
+	}
+	objects = objects[:limit]
+	next := objects[limit-1].ID
+	return objects, &next, nil
+}
+
+// 执行移动，对象和文件夹都必须属于该用户；数据库修改在一个事务中完成
+func applyReclassifyMoves(userID int, moves []ReclassifyMove) ([]Object, error) {
+	var moved []Object
+	for _, move := range moves {
+		obj, err := getObjectByID(move.ObjectID)
+		if err == sql.ErrNoRows || (err == nil && obj.UserID != userID) {
+			return nil, validationError(fmt.Sprintf("对象 %d 不存在", move.ObjectID))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		exists, err := folderExists(userID, move.FolderID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, validationError(fmt.Sprintf("文件夹 %d 不存在", move.FolderID))
+		}
+
+		if obj.FolderID != move.FolderID {
+			obj.FolderID = move.FolderID
+			moved = append(moved, obj)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for _, obj := range moved {
+		if _, err := tx.Exec("UPDATE objects SET folder_id = ? WHERE id = ?", obj.FolderID, obj.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return moved, nil
+}
+
+// 重新分类处理器：object_id、folder_id 指定范围，都不指定时处理整个图库。
+// 文件夹和整个图库按ID分批处理，每批最多 RECLASSIFY_BATCH_SIZE 个对象，
+// 响应中的 next_after_id 不为空时用它作为 after_id 请求下一批
+func reclassifyHandler(c *gin.Context) {
+	var req ReclassifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if req.Limit <= 0 || req.Limit > config.ReclassifyBatchSize {
+		req.Limit = config.ReclassifyBatchSize
+	}
+
+	userID := currentUserID(c)
+	var objects []Object
+	var nextAfterID *int
+	var err error
+	switch {
+	case req.ObjectID != nil:
+		obj, err := getObjectByID(*req.ObjectID)
+		if err == sql.ErrNoRows || (err == nil && obj.UserID != userID) {
+			c.JSON(404, gin.H{"error": "Object not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get object: %v", err)})
+			return
+		}
+		objects = []Object{obj}
+
+	case req.FolderID != nil:
+		exists, err := folderExists(userID, *req.FolderID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get folder: %v", err)})
+			return
+		}
+		if !exists {
+			c.JSON(404, gin.H{"error": "Folder not found"})
+			return
+		}
+
+		folderIDs := []int{*req.FolderID}
+		if req.Recursive {
+			if folderIDs, err = getDescendantFolderIDs(*req.FolderID); err != nil {
+				c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get subfolders: %v", err)})
+				return
+			}
+		}
+		objects, nextAfterID, err = nextReclassifyBatch(ObjectFilter{UserID: userID, FolderIDs: folderIDs, AfterID: req.AfterID}, req.Limit)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get objects: %v", err)})
+			return
+		}
+
+	default:
+		if objects, nextAfterID, err = nextReclassifyBatch(ObjectFilter{UserID: userID, AfterID: req.AfterID}, req.Limit); err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get objects: %v", err)})
+			return
+		}
+	}
+
+	proposals, err := proposeReclassification(c.Request.Context(), userID, objects)
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to reclassify: %v", err)})
+		return
+	}
+
+	var moves []ReclassifyMove
+	for _, proposal := range proposals {
+		if proposal.Changed {
+			moves = append(moves, ReclassifyMove{ObjectID: proposal.ObjectID, FolderID: proposal.ProposedFolderID})
+		}
+	}
+
+	if req.Apply {
+		if _, err := applyReclassifyMoves(userID, moves); err != nil {
+			c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to move objects: %v", err)})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"proposals":     proposals,
+		"count":         len(proposals),
+		"changed":       len(moves),
+		"applied":       req.Apply,
+		"next_after_id": nextAfterID,
+	})
+}
+
+// 应用预览时确认过的移动，避免再次调用模型得到不同的结果
+func applyReclassifyHandler(c *gin.Context) {
+	var req ReclassifyApplyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+	if len(req.Moves) == 0 {
+		c.JSON(400, gin.H{"error": "Moves are required"})
+		return
+	}
+
+	moved, err := applyReclassifyMoves(currentUserID(c), req.Moves)
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to move objects: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Objects moved successfully", "moved": len(moved)})
+}
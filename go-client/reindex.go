@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	chromem "github.com/philippgille/chromem-go"
+)
+
+// 向量库一致性检查和重建：向量库与 objects 表分开存储，可能因为写入失败、
+// 旧版本的删除逻辑或更换嵌入模型而不一致。每个用户的向量集合分别检查
+
+// 同一时间只允许一个修复或重建；单个对象写入索引时持有读锁，
+// 避免重建读取对象之后写入的向量随旧集合一起被删除
+var reindexMu sync.RWMutex
+
+// 一致性检查结果
+type IndexReport struct {
+	UserID          int      `json:"user_id"`
+	Objects         int      `json:"objects"`
+	VectorDocs      int      `json:"vector_docs"`
+	MissingVectors  []int    `json:"missing_vectors"`  // 没有任何向量文档的对象
+	OrphanedVectors []string `json:"orphaned_vectors"` // 对象已不存在的向量文档ID
+	FailedObjects   []int    `json:"failed_objects"`   // 索引失败等待重试的对象
+	Consistent      bool     `json:"consistent"`
+}
+
+// 修复或重建的结果
+type ReindexResult struct {
+	Report          IndexReport    `json:"report"` // 执行前的检查结果
+	Rebuilt         bool           `json:"rebuilt"`
+	Indexed         int            `json:"indexed"`          // 重新写入向量的对象数
+	FromDescription []int          `json:"from_description"` // 没有摘要，只能用描述生成向量的对象
+	RemovedVectors  int            `json:"removed_vectors"`  // 删除的孤立向量文档数
+	Failed          map[int]string `json:"failed,omitempty"` // 写入失败的对象及原因
+}
+
+// 检查用户的向量集合与 objects 表是否一致
+func verifyIndex(ctx context.Context, userID int) (IndexReport, error) {
+	objects, err := findObjects(ObjectFilter{UserID: userID})
+	if err != nil {
+		return IndexReport{}, err
+	}
+	docs, err := allVectorDocs(ctx, userID)
+	if err != nil {
+		return IndexReport{}, fmt.Errorf("读取向量文档失败: %v", err)
+	}
+	return compareIndex(userID, objects, docs), nil
+}
+
+func compareIndex(userID int, objects []Object, docs []chromem.Result) IndexReport {
+	report := IndexReport{
+		UserID:          userID,
+		Objects:         len(objects),
+		VectorDocs:      len(docs),
+		MissingVectors:  []int{},
+		OrphanedVectors: []string{},
+		FailedObjects:   []int{},
+	}
+
+	byID := make(map[int]Object, len(objects))
+	for _, obj := range objects {
+		byID[obj.ID] = obj
+	}
+
+	indexed := map[int]bool{}
+	for _, doc := range docs {
+		objectID, err := objectIDFromDocID(doc.ID)
+		if _, ok := byID[objectID]; err != nil || !ok {
+			report.OrphanedVectors = append(report.OrphanedVectors, doc.ID)
+			continue
+		}
+		indexed[objectID] = true
+	}
+
+	for _, obj := range objects {
+		if !indexed[obj.ID] {
+			report.MissingVectors = append(report.MissingVectors, obj.ID)
+		}
+		if obj.IndexState == IndexFailed {
+			report.FailedObjects = append(report.FailedObjects, obj.ID)
+		}
+	}
+
+	report.Consistent = len(report.MissingVectors) == 0 && len(report.OrphanedVectors) == 0
+	return report
+}
+
+// 修复用户向量集合的不一致：删除孤立文档、为缺少向量的对象重新写入
+func repairIndex(ctx context.Context, userID int) (ReindexResult, error) {
+	reindexMu.Lock()
+	defer reindexMu.Unlock()
+
+	objects, err := findObjects(ObjectFilter{UserID: userID})
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	collection, err := userCollection(userID)
+	if err != nil {
+		return ReindexResult{}, err
+	}
+	docs, err := allVectorDocs(ctx, userID)
+	if err != nil {
+		return ReindexResult{}, fmt.Errorf("读取向量文档失败: %v", err)
+	}
+	result := ReindexResult{Report: compareIndex(userID, objects, docs), FromDescription: []int{}}
+
+	if len(result.Report.OrphanedVectors) > 0 {
+		if err := collection.Delete(ctx, nil, nil, result.Report.OrphanedVectors...); err != nil {
+			return result, err
+		}
+		result.RemovedVectors = len(result.Report.OrphanedVectors)
+	}
+
+	byID := make(map[int]Object, len(objects))
+	for _, obj := range objects {
+		byID[obj.ID] = obj
+	}
+
+	for _, id := range result.Report.MissingVectors {
+		reindexObject(byID[id], &result)
+	}
+	return result, nil
+}
+
+// 按数据库中的对象重新生成用户的全部向量并替换原集合，用于更换嵌入模型等情况。
+// 先计算出全部向量，都成功后才删除旧集合并写入，嵌入服务不可用时旧集合保持不变
+func rebuildIndex(ctx context.Context, userID int) (ReindexResult, error) {
+	reindexMu.Lock()
+	defer reindexMu.Unlock()
+
+	objects, err := findObjects(ObjectFilter{UserID: userID})
+	if err != nil {
+		return ReindexResult{}, err
+	}
+
+	// 旧对象没有保存摘要，尽量沿用已有文档的文本；
+	// 更换嵌入模型后旧向量维度不同，可能无法读取，此时只能用描述生成
+	result := ReindexResult{Report: IndexReport{UserID: userID}, Rebuilt: true, FromDescription: []int{}}
+	embed := vectorEmbeddingFunc()
+	existing := map[int][]chromem.Document{}
+	docs, err := allVectorDocs(ctx, userID)
+	if err != nil {
+		// 读取失败也可能是嵌入服务本身不可用，这时重建只会清空集合
+		if _, embedErr := embed(ctx, "instago"); embedErr != nil {
+			return result, fmt.Errorf("嵌入服务不可用，未修改向量集合: %v", embedErr)
+		}
+		slog.Warn("读取已有的向量文档失败，将不沿用旧文档的文本", "user_id", userID, "err", err)
+	} else {
+		result.Report = compareIndex(userID, objects, docs)
+		for _, doc := range docs {
+			if objectID, err := objectIDFromDocID(doc.ID); err == nil {
+				existing[objectID] = append(existing[objectID], chromem.Document{
+					ID:       doc.ID,
+					Content:  doc.Content,
+					Metadata: doc.Metadata,
+				})
+			}
+		}
+	}
+
+	var newDocs []chromem.Document
+	for _, obj := range objects {
+		objDocs := buildVectorDocs(obj)
+		if obj.Digest == "" {
+			if len(existing[obj.ID]) > 0 {
+				objDocs = oldVectorDocs(obj, existing[obj.ID])
+			} else {
+				result.FromDescription = append(result.FromDescription, obj.ID)
+			}
+		}
+		if err := embedVectorDocs(ctx, embed, objDocs); err != nil {
+			if result.Failed == nil {
+				result.Failed = map[int]string{}
+			}
+			result.Failed[obj.ID] = err.Error()
+			continue
+		}
+		newDocs = append(newDocs, objDocs...)
+	}
+	if len(result.Failed) > 0 {
+		return result, fmt.Errorf("%d 个对象生成向量失败，未修改向量集合", len(result.Failed))
+	}
+
+	// 替换集合：文档已带有向量，写入时不再调用嵌入服务
+	if err := vecDB.DeleteCollection(vectorCollectionName(userID)); err != nil {
+		return result, err
+	}
+	collection, err := userCollection(userID)
+	if err != nil {
+		return result, err
+	}
+	for _, doc := range newDocs {
+		if err := collection.AddDocument(ctx, doc); err != nil {
+			return result, fmt.Errorf("写入向量集合失败: %v", err)
+		}
+	}
+
+	for _, obj := range objects {
+		if err := indexObjectText(obj); err != nil {
+			markIndexFailed(obj.ID, err)
+			if result.Failed == nil {
+				result.Failed = map[int]string{}
+			}
+			result.Failed[obj.ID] = err.Error()
+			continue
+		}
+		if err := markIndexed(obj); err != nil {
+			return result, err
+		}
+		result.Indexed++
+	}
+	slog.Info("向量集合重建完成", "user_id", userID, "objects", len(objects), "vector_docs", collection.Count())
+	return result, nil
+}
+
+// 为缺少向量的对象重新写入，FromDescription 记录只能用描述生成的对象
+func reindexObject(obj Object, result *ReindexResult) {
+	if obj.Digest == "" {
+		result.FromDescription = append(result.FromDescription, obj.ID)
+	}
+	if err := writeObjectIndex(obj); err != nil {
+		if result.Failed == nil {
+			result.Failed = map[int]string{}
+		}
+		result.Failed[obj.ID] = err.Error()
+		return
+	}
+	result.Indexed++
+}
+
+// 沿用旧文档的文本，元数据按对象当前状态生成
+func oldVectorDocs(obj Object, oldDocs []chromem.Document) []chromem.Document {
+	docs := make([]chromem.Document, 0, len(oldDocs))
+	for _, doc := range oldDocs {
+		docType := doc.Metadata["type"]
+		if docType == "" {
+			docType = "main"
+		}
+		docs = append(docs, chromem.Document{
+			ID:       doc.ID,
+			Content:  doc.Content,
+			Metadata: vectorMetadata(obj, docType),
+		})
+	}
+	return docs
+}
+
+// 计算文档的向量，不写入向量库
+func embedVectorDocs(ctx context.Context, embed chromem.EmbeddingFunc, docs []chromem.Document) error {
+	for i := range docs {
+		embedding, err := embed(ctx, docs[i].Content)
+		if err != nil {
+			return err
+		}
+		docs[i].Embedding = embedding
+	}
+	return nil
+}
+
+// 要维护的用户：user_id 参数指定时只处理该用户，否则处理所有用户
+func indexUserIDs(userParam string) ([]int, error) {
+	if userParam != "" {
+		userID, err := strconv.Atoi(userParam)
+		if err != nil {
+			return nil, validationError("无效的用户ID")
+		}
+		exists, err := userExists(userID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, validationError(fmt.Sprintf("用户 %d 不存在", userID))
+		}
+		return []int{userID}, nil
+	}
+
+	users, err := listUsers()
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for _, user := range users {
+		ids = append(ids, user.ID)
+	}
+	return ids, nil
+}
+
+// 一致性检查处理器
+func verifyIndexHandler(c *gin.Context) {
+	userIDs, err := indexUserIDs(c.Query("user_id"))
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to verify index: %v", err)})
+		return
+	}
+
+	reports := []IndexReport{}
+	for _, userID := range userIDs {
+		report, err := verifyIndex(c.Request.Context(), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to verify index of user %d: %v", userID, err)})
+			return
+		}
+		reports = append(reports, report)
+	}
+	c.JSON(200, gin.H{"reports": reports})
+}
+
+// 修复或重建处理器，all=true 时重建整个向量集合
+func reindexHandler(c *gin.Context) {
+	userIDs, err := indexUserIDs(c.Query("user_id"))
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to reindex: %v", err)})
+		return
+	}
+
+	// 客户端断开时也要执行完，不使用请求的context
+	ctx := context.Background()
+	results := []ReindexResult{}
+	for _, userID := range userIDs {
+		var result ReindexResult
+		if c.Query("all") == "true" {
+			result, err = rebuildIndex(ctx, userID)
+		} else {
+			result, err = repairIndex(ctx, userID)
+		}
+		if err != nil {
+			c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to reindex user %d: %v", userID, err)})
+			return
+		}
+		results = append(results, result)
+	}
+	c.JSON(200, gin.H{"results": results})
+}
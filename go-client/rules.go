@@ -0,0 +1,185 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 分类规则：已知类别用确定的规则归档，只有规则都不匹配时才由文本模型推荐文件夹
+
+// 规则类型
+const (
+	RuleAppName = "app_name" // 来源应用等于（不区分大小写）
+	RuleTag     = "tag"      // 标签中包含（不区分大小写）
+	RuleKeyword = "keyword"  // 图片描述匹配正则表达式
+)
+
+// 分类规则，按 priority 从小到大依次匹配，第一个命中的规则决定文件夹
+type FolderRule struct {
+	ID        int       `json:"id"`
+	FolderID  int       `json:"folder_id"`
+	Type      string    `json:"type"`
+	Pattern   string    `json:"pattern"`
+	Priority  int       `json:"priority"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// 获取用户的全部规则，规则属于目标文件夹的用户，目标文件夹已不存在的规则会被忽略
+func listFolderRules(userID int) ([]FolderRule, error) {
+	rows, err := db.Query(`
+	SELECT r.id, r.folder_id, r.type, r.pattern, r.priority, r.created_at
+	FROM folder_rules r JOIN folders f ON f.id = r.folder_id
+	WHERE f.user_id = ?
+	ORDER BY r.priority, r.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []FolderRule{}
+	for rows.Next() {
+		var rule FolderRule
+		if err := rows.Scan(&rule.ID, &rule.FolderID, &rule.Type, &rule.Pattern, &rule.Priority, &rule.CreatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// 校验并创建规则
+func createFolderRule(userID int, rule FolderRule) (int, error) {
+	rule.Pattern = strings.TrimSpace(rule.Pattern)
+	if rule.Pattern == "" {
+		return 0, validationError("规则内容不能为空")
+	}
+
+	switch rule.Type {
+	case RuleAppName, RuleTag:
+	case RuleKeyword:
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return 0, validationError(fmt.Sprintf("无效的正则表达式: %v", err))
+		}
+	default:
+		return 0, validationError(fmt.Sprintf("无效的规则类型 '%s'，应为 app_name、tag 或 keyword", rule.Type))
+	}
+
+	exists, err := folderExists(userID, rule.FolderID)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		return 0, validationError("文件夹不存在")
+	}
+
+	result, err := db.Exec("INSERT INTO folder_rules (folder_id, type, pattern, priority) VALUES (?, ?, ?, ?)",
+		rule.FolderID, rule.Type, rule.Pattern, rule.Priority)
+	if err != nil {
+		return 0, err
+	}
+	id, err := result.LastInsertId()
+	return int(id), err
+}
+
+// 删除用户的规则
+func deleteFolderRule(userID, id int) error {
+	result, err := db.Exec("DELETE FROM folder_rules WHERE id = ? AND folder_id IN (SELECT id FROM folders WHERE user_id = ?)",
+		id, userID)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// 按优先级查找第一个匹配的规则，没有匹配时返回 nil
+func matchFolderRule(userID int, appName, tags, description string) (*FolderRule, error) {
+	rules, err := listFolderRules(userID)
+	if err != nil {
+		return nil, err
+	}
+	for _, rule := range rules {
+		if ruleMatches(rule, appName, tags, description) {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+func ruleMatches(rule FolderRule, appName, tags, description string) bool {
+	switch rule.Type {
+	case RuleAppName:
+		return strings.EqualFold(strings.TrimSpace(appName), rule.Pattern)
+	case RuleTag:
+		for _, tag := range splitTags(tags) {
+			if strings.EqualFold(tag, rule.Pattern) {
+				return true
+			}
+		}
+	case RuleKeyword:
+		re, err := regexp.Compile(rule.Pattern)
+		return err == nil && re.MatchString(description)
+	}
+	return false
+}
+
+// 获取规则列表处理器
+func listFolderRulesHandler(c *gin.Context) {
+	rules, err := listFolderRules(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get rules: %v", err)})
+		return
+	}
+	c.JSON(200, gin.H{"rules": rules})
+}
+
+// 创建规则处理器
+func createFolderRuleHandler(c *gin.Context) {
+	var req FolderRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	id, err := createFolderRule(currentUserID(c), FolderRule{
+		FolderID: req.FolderID,
+		Type:     req.Type,
+		Pattern:  req.Pattern,
+		Priority: req.Priority,
+	})
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to create rule: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Rule created successfully", "id": id})
+}
+
+// 删除规则处理器
+func deleteFolderRuleHandler(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(400, gin.H{"error": "Invalid rule ID"})
+		return
+	}
+
+	err = deleteFolderRule(currentUserID(c), id)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Rule not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to delete rule: %v", err)})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Rule deleted successfully"})
+}
@@ -0,0 +1,44 @@
+package main
+
+import "sort"
+
+// 搜索模式
+const (
+	SearchModeVector  = "vector"
+	SearchModeKeyword = "keyword"
+	SearchModeHybrid  = "hybrid"
+)
+
+// RRF 平滑常数，降低排名靠前结果之间的分差
+const rrfK = 60
+
+// 倒数排名融合（Reciprocal Rank Fusion）：score = Σ 1/(k + rank)
+// 只依赖排名，不需要把余弦相似度和 BM25 分数归一化到同一尺度
+func fuseRankings(rankings ...[]int) ([]int, map[int]float64) {
+	scores := make(map[int]float64)
+	var ids []int
+	for _, ranking := range rankings {
+		for rank, id := range ranking {
+			if _, ok := scores[id]; !ok {
+				ids = append(ids, id)
+			}
+			scores[id] += 1.0 / float64(rrfK+rank+1)
+		}
+	}
+
+	sort.SliceStable(ids, func(i, j int) bool {
+		return scores[ids[i]] > scores[ids[j]]
+	})
+	return ids, scores
+}
+
+// 过滤掉不在 objects 中的ID，保持原有顺序
+func existingIDs(ids []int, objects map[int]Object) []int {
+	var result []int
+	for _, id := range ids {
+		if _, ok := objects[id]; ok {
+			result = append(result, id)
+		}
+	}
+	return result
+}
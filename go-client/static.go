@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 前端页面编译进二进制，只对外提供这里列出的文件，
+// 工作目录中的 .env、数据库和blob不会通过静态路由暴露
+//
+//go:embed frontend.html test.html
+var staticFiles embed.FS
+
+var staticFileNames = []string{"frontend.html", "test.html"}
+
+// 注册前端页面路由，/static/ 下保留旧的访问路径
+func registerStaticRoutes(router *gin.Engine) {
+	files := http.FS(staticFiles)
+	router.StaticFileFS("/", "frontend.html", files)
+	for _, name := range staticFileNames {
+		router.StaticFileFS("/"+name, name, files)
+		router.StaticFileFS("/static/"+name, name, files)
+	}
+}
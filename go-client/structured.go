@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// 模型的结构化输出：模型经常在JSON外面包上markdown代码块或加几句说明，
+// 字段类型也不总是按要求返回，这里尽量宽容地解析，解析或校验失败时让模型修复一次
+
+// 调用文本模型生成JSON，并用 parse 解析和校验；输出无效时把问题反馈给模型重新生成一次
+func generateStructured(ctx context.Context, prompt string, parse func(content string) error) error {
+	content, err := generateJSON(ctx, textModel, prompt)
+	if err != nil {
+		return err
+	}
+	parseErr := parse(content)
+	if parseErr == nil {
+		return nil
+	}
+
+	slog.WarnContext(ctx, "模型输出无效，尝试修复", "err", parseErr)
+	repaired, err := generateJSON(ctx, textModel, repairPrompt(prompt, content, parseErr))
+	if err != nil {
+		return err
+	}
+	if err := parse(repaired); err != nil {
+		return fmt.Errorf("模型输出无效（已尝试修复一次）: %v", err)
+	}
+	return nil
+}
+
+func repairPrompt(prompt, content string, problem error) string {
+	return fmt.Sprintf(`你之前根据下面的要求生成了一段回复，但它不是符合要求的JSON。
+
+原始要求：
+%s
+
+你的回复：
+%s
+
+存在的问题：
+%v
+
+请修正上述问题，只返回一个JSON对象，不要使用markdown代码块，也不要附加任何说明。
+`, prompt, content, problem)
+}
+
+// 从模型输出中提取最外层的JSON对象并解码
+func decodeJSONObject(content string) (map[string]interface{}, error) {
+	text := stripCodeFence(content)
+	for start := strings.IndexByte(text, '{'); start >= 0; {
+		if end := matchingBrace(text, start); end > start {
+			var fields map[string]interface{}
+			if err := json.Unmarshal([]byte(text[start:end+1]), &fields); err == nil {
+				return fields, nil
+			}
+		}
+		// 说明文字里也可能出现花括号，继续尝试后面的位置
+		next := strings.IndexByte(text[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	return nil, fmt.Errorf("回复中没有找到JSON对象")
+}
+
+// 去掉 ```json ... ``` 代码块标记，没有代码块时原样返回
+func stripCodeFence(content string) string {
+	text := strings.TrimSpace(content)
+	start := strings.Index(text, "```")
+	if start < 0 {
+		return text
+	}
+	body := text[start+3:]
+	// 跳过语言标记所在的行
+	if newline := strings.IndexByte(body, '\n'); newline >= 0 {
+		body = body[newline+1:]
+	}
+	if end := strings.Index(body, "```"); end >= 0 {
+		body = body[:end]
+	}
+	return strings.TrimSpace(body)
+}
+
+// 找到与 start 处的 '{' 配对的 '}'，跳过字符串中的括号；没有配对时返回 -1
+func matchingBrace(text string, start int) int {
+	depth := 0
+	inString := false
+	escaped := false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case ch == '\\':
+				escaped = true
+			case ch == '"':
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// 以下函数把模型返回的字段转换为需要的类型，无法转换时返回零值
+
+func jsonString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case []interface{}:
+		return strings.Join(jsonStrings(v), ",")
+	}
+	return ""
+}
+
+// 字符串列表，模型返回单个字符串时按行拆分
+func jsonStrings(value interface{}) []string {
+	var result []string
+	switch v := value.(type) {
+	case []interface{}:
+		for _, item := range v {
+			if s := jsonString(item); s != "" {
+				result = append(result, s)
+			}
+		}
+	case string:
+		for _, line := range strings.Split(v, "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				result = append(result, line)
+			}
+		}
+	}
+	return result
+}
+
+// 0到1之间的置信度，超出范围时截断
+func jsonConfidence(value interface{}) float64 {
+	var confidence float64
+	switch v := value.(type) {
+	case float64:
+		confidence = v
+	case string:
+		confidence, _ = strconv.ParseFloat(strings.TrimSpace(v), 64)
+	}
+	if confidence < 0 {
+		return 0
+	}
+	if confidence > 1 {
+		return 1
+	}
+	return confidence
+}
+
+// 保留原始的JSON值，交给 parseFolderID 解析；字段不存在时返回空字符串
+func jsonRaw(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(raw)
+}
+
+// 解析文本模型生成的搜索内容
+func parseSearchContent(content string) (SearchContent, error) {
+	fields, err := decodeJSONObject(content)
+	if err != nil {
+		return SearchContent{}, err
+	}
+	sc := SearchContent{
+		Name:            jsonString(fields["name"]),
+		Digest:          jsonString(fields["digest"]),
+		Keywords:        jsonString(fields["keywords"]),
+		Questions:       jsonStrings(fields["questions"]),
+		Scenario:        jsonString(fields["scenario"]),
+		FromSite:        jsonString(fields["from_site"]),
+		OriginContent:   jsonString(fields["origin_content"]),
+		SuggestedFolder: jsonRaw(fields["folder_id"]),
+		Confidence:      jsonConfidence(fields["confidence"]),
+	}
+	return sc, validateSearchContent(sc)
+}
+
+// 校验搜索内容的必填字段；推荐的文件夹无效时会放入收件箱，这里不要求
+func validateSearchContent(sc SearchContent) error {
+	var problems []string
+	if sc.Name == "" {
+		problems = append(problems, "name 不能为空")
+	}
+	if sc.Digest == "" {
+		problems = append(problems, "digest 不能为空")
+	}
+	if sc.Keywords == "" {
+		problems = append(problems, "keywords 不能为空")
+	}
+	if len(sc.Questions) == 0 {
+		problems = append(problems, "questions 应为非空的字符串数组")
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "；"))
+	}
+	return nil
+}
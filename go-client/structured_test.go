@@ -0,0 +1,108 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestStripCodeFence(t *testing.T) {
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{`{"a": 1}`, `{"a": 1}`},
+		{"  {\"a\": 1}\n", `{"a": 1}`},
+		{"```json\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"```\n{\"a\": 1}\n```", `{"a": 1}`},
+		{"好的，结果如下：\n```json\n{\"a\": 1}\n```\n希望有帮助", `{"a": 1}`},
+		{"```json\n{\"a\": 1}", `{"a": 1}`}, // 缺少结束标记
+	}
+
+	for _, tt := range tests {
+		if got := stripCodeFence(tt.content); got != tt.want {
+			t.Errorf("%q: 得到 %q，期望 %q", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeJSONObject(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string]interface{} // 为 nil 表示应返回错误
+	}{
+		{"纯JSON", `{"name": "截图"}`, map[string]interface{}{"name": "截图"}},
+		{"代码块", "```json\n{\"name\": \"截图\"}\n```", map[string]interface{}{"name": "截图"}},
+		{"前后有说明", `结果如下 {"name": "截图"} 以上`, map[string]interface{}{"name": "截图"}},
+		{"说明中有花括号", `格式为 {名称} ：{"name": "截图"}`, map[string]interface{}{"name": "截图"}},
+		{"字符串中有花括号", `{"name": "a}b{c"}`, map[string]interface{}{"name": "a}b{c"}},
+		{"嵌套对象", `{"a": {"b": 1}}`, map[string]interface{}{"a": map[string]interface{}{"b": float64(1)}}},
+		{"没有JSON", `无法识别图片内容`, nil},
+		{"不完整", `{"name": "截图"`, nil},
+	}
+
+	for _, tt := range tests {
+		got, err := decodeJSONObject(tt.content)
+		if tt.want == nil {
+			if err == nil {
+				t.Errorf("%s: 期望返回错误，得到 %v", tt.name, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: 返回错误 %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: 得到 %v，期望 %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParseSearchContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    SearchContent
+		problem string // 期望的错误中包含的内容，为空表示应成功
+	}{
+		{
+			name:    "完整",
+			content: "```json\n{\"name\": \"力扣\", \"digest\": \"两数之和\", \"keywords\": \"leetcode\", \"questions\": [\"数组题\"], \"folder_id\": 3, \"confidence\": 0.8}\n```",
+			want:    SearchContent{Name: "力扣", Digest: "两数之和", Keywords: "leetcode", Questions: []string{"数组题"}, SuggestedFolder: "3", Confidence: 0.8},
+		},
+		{
+			name:    "类型转换",
+			content: `{"name": "力扣", "digest": "两数之和", "keywords": ["leetcode", "算法"], "questions": "数组题\n哈希表", "folder_id": "3", "confidence": "1.5"}`,
+			want:    SearchContent{Name: "力扣", Digest: "两数之和", Keywords: "leetcode,算法", Questions: []string{"数组题", "哈希表"}, SuggestedFolder: `"3"`, Confidence: 1},
+		},
+		{
+			name:    "缺少字段",
+			content: `{"digest": "两数之和", "keywords": "leetcode", "questions": []}`,
+			problem: "name 不能为空",
+		},
+		{
+			name:    "问题为空",
+			content: `{"name": "力扣", "digest": "两数之和", "keywords": "leetcode"}`,
+			problem: "questions",
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := parseSearchContent(tt.content)
+		if tt.problem != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Errorf("%s: 错误为 %v，期望包含 %q", tt.name, err, tt.problem)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: 返回错误 %v", tt.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: 得到 %+v，期望 %+v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 基于规则的相对时间解析：把查询中的"昨天下午"、"三天前"、"last week"等短语
+// 转换为截图时间戳范围，并从语义查询中去掉这些短语。
+// 之前尝试用小模型计算时间戳，结果不稳定，这里只依赖当前时间，结果是确定的。
+
+// 解析出的时间范围，时间戳为毫秒，From 含、To 不含
+type TimeRange struct {
+	Phrase   string `json:"phrase"`
+	From     int64  `json:"from"`
+	To       int64  `json:"to"`
+	FromTime string `json:"from_time"`
+	ToTime   string `json:"to_time"`
+}
+
+type timeRule struct {
+	re      *regexp.Regexp
+	resolve func(m []string, now time.Time) (from, to time.Time, ok bool)
+}
+
+const (
+	cnNumber = `([0-9]+|[零一二两三四五六七八九十]+)`
+	enNumber = `([0-9]+|an?|one|two|three|four|five|six|seven|eight|nine|ten|eleven|twelve)`
+	cnDay    = `(大前天|前天|昨天|昨日|今天|今日)`
+	cnPart   = `(凌晨|早上|早晨|上午|中午|下午|傍晚|晚上|夜里)`
+	enPart   = `(morning|afternoon|evening|night)`
+	// 中文短语后的"的"一起去掉，英文短语前的介词一起去掉
+	cnSuffix = `(?:的)?`
+	enPrefix = `(?i)(?:\b(?:from|on|in|during|since|taken)\s+)?\b`
+)
+
+// 一天中各时段的起止小时
+var dayParts = map[string][2]int{
+	"凌晨": {0, 6}, "早上": {6, 12}, "早晨": {6, 12}, "上午": {6, 12}, "中午": {11, 14},
+	"下午": {12, 18}, "傍晚": {17, 20}, "晚上": {18, 24}, "夜里": {18, 24},
+	"morning": {6, 12}, "afternoon": {12, 18}, "evening": {18, 24}, "night": {18, 24},
+}
+
+var cnDayOffsets = map[string]int{"大前天": -3, "前天": -2, "昨天": -1, "昨日": -1, "今天": 0, "今日": 0}
+
+var cnWeekdays = map[string]time.Weekday{
+	"一": time.Monday, "二": time.Tuesday, "三": time.Wednesday, "四": time.Thursday,
+	"五": time.Friday, "六": time.Saturday, "日": time.Sunday, "天": time.Sunday,
+	"1": time.Monday, "2": time.Tuesday, "3": time.Wednesday, "4": time.Thursday,
+	"5": time.Friday, "6": time.Saturday, "7": time.Sunday,
+}
+
+var enWeekdays = map[string]time.Weekday{
+	"monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday, "sunday": time.Sunday,
+}
+
+// 规则按优先级排列，越具体的越靠前
+var timeRules = []timeRule{
+	// 昨天下午、今天、前天晚上
+	{regexp.MustCompile(cnDay + cnPart + `?` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := startOfDay(now).AddDate(0, 0, cnDayOffsets[m[1]])
+		return dayPartRange(day, m[2])
+	}},
+	// 今早、今晚、昨晚
+	{regexp.MustCompile(`(今|昨)(早|晚)` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := startOfDay(now)
+		if m[1] == "昨" {
+			day = day.AddDate(0, 0, -1)
+		}
+		part := "早上"
+		if m[2] == "晚" {
+			part = "晚上"
+		}
+		return dayPartRange(day, part)
+	}},
+	// 最近3天、过去两小时、近一周
+	{regexp.MustCompile(`(?:最近|近|过去)` + cnNumber + `(?:个)?(小时|钟头|天|日|周|星期|礼拜|月)` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		n, ok := parseCount(m[1])
+		if !ok {
+			return time.Time{}, time.Time{}, false
+		}
+		return lastNRange(now, n, cnUnit(m[2]))
+	}},
+	// 三天前、两小时前、一周前、三个月前
+	{regexp.MustCompile(cnNumber + `(?:个)?(小时|钟头|天|日|周|星期|礼拜|月)(?:之|以)?前` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		n, ok := parseCount(m[1])
+		if !ok {
+			return time.Time{}, time.Time{}, false
+		}
+		return agoRange(now, n, cnUnit(m[2]))
+	}},
+	// 本周、上周、上周三、上周三晚上
+	{regexp.MustCompile(`(本|这|这个|上|上个|上上|上上个)(周|星期|礼拜)([一二三四五六日天1-7])?` + cnPart + `?` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		week := startOfWeek(now)
+		switch m[1] {
+		case "上", "上个":
+			week = week.AddDate(0, 0, -7)
+		case "上上", "上上个":
+			week = week.AddDate(0, 0, -14)
+		}
+		if m[3] == "" {
+			if m[4] != "" {
+				return time.Time{}, time.Time{}, false // "上周晚上"没有确定的日期
+			}
+			return week, week.AddDate(0, 0, 7), true
+		}
+		day := week.AddDate(0, 0, weekdayOffset(cnWeekdays[m[3]]))
+		return dayPartRange(day, m[4])
+	}},
+	// 周三、星期五下午：最近的一个（含今天）
+	{regexp.MustCompile(`(?:周|星期|礼拜)([一二三四五六日天])` + cnPart + `?` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		return dayPartRange(lastWeekday(now, cnWeekdays[m[1]], false), m[2])
+	}},
+	// 本月、上个月
+	{regexp.MustCompile(`(本|这个|上|上个)月` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		month := startOfMonth(now)
+		if strings.HasPrefix(m[1], "上") {
+			month = month.AddDate(0, -1, 0)
+		}
+		return month, month.AddDate(0, 1, 0), true
+	}},
+	// 今年、去年、前年
+	{regexp.MustCompile(`(今年|去年|前年)` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		year := startOfYear(now).AddDate(map[string]int{"今年": 0, "去年": -1, "前年": -2}[m[1]], 0, 0)
+		return year, year.AddDate(1, 0, 0), true
+	}},
+	// 刚才、刚刚：最近一小时
+	{regexp.MustCompile(`(刚才|刚刚)` + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		return lastNRange(now, 1, time.Hour)
+	}},
+
+	// the day before yesterday
+	{regexp.MustCompile(enPrefix + `(?:the\s+)?day\s+before\s+yesterday\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := startOfDay(now).AddDate(0, 0, -2)
+		return day, day.AddDate(0, 0, 1), true
+	}},
+	// yesterday afternoon, today, this morning, tonight, last night
+	{regexp.MustCompile(enPrefix + `(yesterday|today|this|last|to)\s*` + enPart + `\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := startOfDay(now)
+		first, part := strings.ToLower(m[1]), strings.ToLower(m[2])
+		if first == "yesterday" || first == "last" {
+			day = day.AddDate(0, 0, -1)
+		}
+		if first == "last" && part != "night" {
+			return time.Time{}, time.Time{}, false
+		}
+		if first == "to" && part != "night" {
+			return time.Time{}, time.Time{}, false
+		}
+		return dayPartRange(day, part)
+	}},
+	{regexp.MustCompile(enPrefix + `(yesterday|today)\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := startOfDay(now)
+		if strings.EqualFold(m[1], "yesterday") {
+			day = day.AddDate(0, 0, -1)
+		}
+		return day, day.AddDate(0, 0, 1), true
+	}},
+	// last 3 days, past two hours, in the last week
+	{regexp.MustCompile(enPrefix + `(?:the\s+)?(?:last|past|previous)\s+` + enNumber + `\s+(hours?|days?|weeks?|months?)\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		n, ok := parseCount(m[1])
+		if !ok {
+			return time.Time{}, time.Time{}, false
+		}
+		return lastNRange(now, n, enUnit(m[2]))
+	}},
+	// 3 days ago, an hour ago
+	{regexp.MustCompile(enPrefix + enNumber + `\s+(hours?|days?|weeks?|months?)\s+ago\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		n, ok := parseCount(m[1])
+		if !ok {
+			return time.Time{}, time.Time{}, false
+		}
+		return agoRange(now, n, enUnit(m[2]))
+	}},
+	// this week, last month, last year
+	{regexp.MustCompile(enPrefix + `(this|last)\s+(week|month|year)\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		last := strings.EqualFold(m[1], "last")
+		switch strings.ToLower(m[2]) {
+		case "week":
+			week := startOfWeek(now)
+			if last {
+				week = week.AddDate(0, 0, -7)
+			}
+			return week, week.AddDate(0, 0, 7), true
+		case "month":
+			month := startOfMonth(now)
+			if last {
+				month = month.AddDate(0, -1, 0)
+			}
+			return month, month.AddDate(0, 1, 0), true
+		default:
+			year := startOfYear(now)
+			if last {
+				year = year.AddDate(-1, 0, 0)
+			}
+			return year, year.AddDate(1, 0, 0), true
+		}
+	}},
+	// monday, last friday, saturday night
+	{regexp.MustCompile(enPrefix + `(last\s+)?(monday|tuesday|wednesday|thursday|friday|saturday|sunday)(?:\s+` + enPart + `)?\b`), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		day := lastWeekday(now, enWeekdays[strings.ToLower(m[2])], m[1] != "")
+		return dayPartRange(day, strings.ToLower(m[3]))
+	}},
+}
+
+// 单独出现的时段默认指今天："下午"、"晚上 会议"。
+// 时段前后必须是查询的开头结尾、空白或标点，"下午茶"、"上午好"这样的词不算时间
+var standaloneRules = []timeRule{
+	{regexp.MustCompile(cnPart + cnSuffix), func(m []string, now time.Time) (time.Time, time.Time, bool) {
+		return dayPartRange(startOfDay(now), m[1])
+	}},
+}
+
+// 从查询中解析时间表达式，返回去掉时间短语后的查询和时间范围；没有时间表达式时范围为 nil
+func parseTimeExpression(query string, now time.Time) (string, *TimeRange) {
+	for _, rule := range timeRules {
+		if rest, timeRange := matchTimeRule(rule, query, now, false); timeRange != nil {
+			return rest, timeRange
+		}
+	}
+	for _, rule := range standaloneRules {
+		if rest, timeRange := matchTimeRule(rule, query, now, true); timeRange != nil {
+			return rest, timeRange
+		}
+	}
+	return query, nil
+}
+
+// 用一条规则匹配查询，取第一个能解析的短语
+func matchTimeRule(rule timeRule, query string, now time.Time, standalone bool) (string, *TimeRange) {
+	for _, loc := range rule.re.FindAllStringSubmatchIndex(query, -1) {
+		if standalone && !atBoundary(query, loc[0], loc[1]) {
+			continue
+		}
+		m := make([]string, len(loc)/2)
+		for i := range m {
+			if loc[2*i] >= 0 {
+				m[i] = query[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		from, to, ok := rule.resolve(m, now)
+		if !ok {
+			continue
+		}
+
+		// 去掉短语两侧的分隔符，"报错，下午" 只剩 "报错"
+		before := strings.TrimRightFunc(query[:loc[0]], isQuerySeparator)
+		after := strings.TrimLeftFunc(query[loc[1]:], isQuerySeparator)
+		rest := strings.Join(strings.Fields(before+" "+after), " ")
+		return rest, &TimeRange{
+			Phrase:   strings.TrimSuffix(strings.TrimSpace(m[0]), "的"),
+			From:     from.UnixMilli(),
+			To:       to.UnixMilli(),
+			FromTime: from.Format("2006-01-02 15:04"),
+			ToTime:   to.Format("2006-01-02 15:04"),
+		}
+	}
+	return query, nil
+}
+
+// 时间短语两侧的分隔符：空白和中英文的句读标点，不包括 C#、C++ 中的符号
+func isQuerySeparator(r rune) bool {
+	return unicode.IsSpace(r) || strings.ContainsRune("，,。.、；;：:！!？?", r)
+}
+
+// 短语前后是否是查询的开头结尾、空白或标点
+func atBoundary(query string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(query[:start])
+	after, _ := utf8.DecodeRuneInString(query[end:])
+	return (start == 0 || unicode.IsSpace(before) || unicode.IsPunct(before)) &&
+		(end == len(query) || unicode.IsSpace(after) || unicode.IsPunct(after))
+}
+
+// 某天的某个时段，时段为空时返回整天
+func dayPartRange(day time.Time, part string) (time.Time, time.Time, bool) {
+	if part == "" {
+		return day, day.AddDate(0, 0, 1), true
+	}
+	hours, ok := dayParts[part]
+	if !ok {
+		return time.Time{}, time.Time{}, false
+	}
+	return day.Add(time.Duration(hours[0]) * time.Hour), day.Add(time.Duration(hours[1]) * time.Hour), true
+}
+
+// 时间单位，月份长度不固定，用 0 表示
+const monthUnit time.Duration = 0
+
+// 最近 n 个单位：小时精确到整点，天以上从当天零点往前推，到当前时间所在的整点/整天结束
+func lastNRange(now time.Time, n int, unit time.Duration) (time.Time, time.Time, bool) {
+	if n <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	switch unit {
+	case time.Hour:
+		end := now.Truncate(time.Hour).Add(time.Hour)
+		return end.Add(-time.Duration(n+1) * time.Hour), end, true
+	case monthUnit:
+		end := startOfDay(now).AddDate(0, 0, 1)
+		return end.AddDate(0, -n, 0), end, true
+	default:
+		days := int(unit / (24 * time.Hour))
+		end := startOfDay(now).AddDate(0, 0, 1)
+		return end.AddDate(0, 0, -n*days), end, true
+	}
+}
+
+// n 个单位之前：落在的那个整点、那天、那周或那个月
+func agoRange(now time.Time, n int, unit time.Duration) (time.Time, time.Time, bool) {
+	if n <= 0 {
+		return time.Time{}, time.Time{}, false
+	}
+	switch unit {
+	case time.Hour:
+		start := now.Truncate(time.Hour).Add(-time.Duration(n) * time.Hour)
+		return start, start.Add(time.Hour), true
+	case monthUnit:
+		month := startOfMonth(now).AddDate(0, -n, 0)
+		return month, month.AddDate(0, 1, 0), true
+	case 7 * 24 * time.Hour:
+		week := startOfWeek(now).AddDate(0, 0, -7*n)
+		return week, week.AddDate(0, 0, 7), true
+	default:
+		day := startOfDay(now).AddDate(0, 0, -n)
+		return day, day.AddDate(0, 0, 1), true
+	}
+}
+
+func cnUnit(s string) time.Duration {
+	switch s {
+	case "小时", "钟头":
+		return time.Hour
+	case "周", "星期", "礼拜":
+		return 7 * 24 * time.Hour
+	case "月":
+		return monthUnit
+	default:
+		return 24 * time.Hour
+	}
+}
+
+func enUnit(s string) time.Duration {
+	switch strings.TrimSuffix(strings.ToLower(s), "s") {
+	case "hour":
+		return time.Hour
+	case "week":
+		return 7 * 24 * time.Hour
+	case "month":
+		return monthUnit
+	default:
+		return 24 * time.Hour
+	}
+}
+
+var enNumbers = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "eleven": 11, "twelve": 12,
+}
+
+var cnDigits = map[rune]int{'零': 0, '一': 1, '二': 2, '两': 2, '三': 3, '四': 4, '五': 5, '六': 6, '七': 7, '八': 8, '九': 9}
+
+// 解析阿拉伯数字、英文数字和不超过两位的中文数字
+func parseCount(s string) (int, bool) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, true
+	}
+	if n, ok := enNumbers[strings.ToLower(s)]; ok {
+		return n, true
+	}
+
+	runes := []rune(s)
+	switch {
+	case len(runes) == 1 && runes[0] == '十':
+		return 10, true
+	case len(runes) == 1:
+		n, ok := cnDigits[runes[0]]
+		return n, ok
+	case len(runes) == 2 && runes[0] == '十': // 十二
+		n, ok := cnDigits[runes[1]]
+		return 10 + n, ok
+	case len(runes) == 2 && runes[1] == '十': // 二十
+		n, ok := cnDigits[runes[0]]
+		return n * 10, ok
+	case len(runes) == 3 && runes[1] == '十': // 二十三
+		tens, ok1 := cnDigits[runes[0]]
+		ones, ok2 := cnDigits[runes[2]]
+		return tens*10 + ones, ok1 && ok2
+	}
+	return 0, false
+}
+
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// 一周从周一开始
+func startOfWeek(t time.Time) time.Time {
+	return startOfDay(t).AddDate(0, 0, -weekdayOffset(t.Weekday()))
+}
+
+func startOfMonth(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+}
+
+func startOfYear(t time.Time) time.Time {
+	return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+}
+
+// 距离周一的天数
+func weekdayOffset(d time.Weekday) int {
+	return (int(d) + 6) % 7
+}
+
+// 最近的某个星期几；strict 为 true 时不包括今天
+func lastWeekday(now time.Time, d time.Weekday, strict bool) time.Time {
+	diff := (int(now.Weekday()) - int(d) + 7) % 7
+	if diff == 0 && strict {
+		diff = 7
+	}
+	return startOfDay(now).AddDate(0, 0, -diff)
+}
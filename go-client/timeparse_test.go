@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeExpression(t *testing.T) {
+	// 2025-06-18 是星期三
+	now := time.Date(2025, 6, 18, 15, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		query string
+		rest  string
+		from  string // 为空表示没有时间范围
+		to    string
+	}{
+		{"昨天下午的截图", "截图", "2025-06-17 12:00", "2025-06-17 18:00"},
+		{"今天", "", "2025-06-18 00:00", "2025-06-19 00:00"},
+		{"今晚 菜单", "菜单", "2025-06-18 18:00", "2025-06-19 00:00"},
+		{"最近3天 报错", "报错", "2025-06-16 00:00", "2025-06-19 00:00"},
+		{"三天前的订单", "订单", "2025-06-15 00:00", "2025-06-16 00:00"},
+		{"刚才", "", "2025-06-18 14:00", "2025-06-18 16:00"},
+		{"上周三 会议", "会议", "2025-06-11 00:00", "2025-06-12 00:00"},
+		{"上周三晚上 会议", "会议", "2025-06-11 18:00", "2025-06-12 00:00"},
+		{"本周", "", "2025-06-16 00:00", "2025-06-23 00:00"},
+		{"周五下午 聊天记录", "聊天记录", "2025-06-13 12:00", "2025-06-13 18:00"},
+		{"周三", "", "2025-06-18 00:00", "2025-06-19 00:00"},
+		{"上个月的账单", "账单", "2025-05-01 00:00", "2025-06-01 00:00"},
+		{"去年", "", "2024-01-01 00:00", "2025-01-01 00:00"},
+
+		// 单独的时段指今天，前后必须是边界
+		{"下午", "", "2025-06-18 12:00", "2025-06-18 18:00"},
+		{"晚上 会议纪要", "会议纪要", "2025-06-18 18:00", "2025-06-19 00:00"},
+		{"报错，下午", "报错", "2025-06-18 12:00", "2025-06-18 18:00"},
+		{"下午，会议纪要", "会议纪要", "2025-06-18 12:00", "2025-06-18 18:00"},
+		{"报错，昨天，登录页", "报错 登录页", "2025-06-17 00:00", "2025-06-18 00:00"},
+		{"C# 报错 昨天", "C# 报错", "2025-06-17 00:00", "2025-06-18 00:00"},
+		{"yesterday, login errors", "login errors", "2025-06-17 00:00", "2025-06-18 00:00"},
+		{"下午茶", "下午茶", "", ""},
+		{"中午吃什么", "中午吃什么", "", ""},
+		{"上午好", "上午好", "", ""},
+
+		{"yesterday afternoon screenshots", "screenshots", "2025-06-17 12:00", "2025-06-17 18:00"},
+		{"tonight", "", "2025-06-18 18:00", "2025-06-19 00:00"},
+		{"last night", "", "2025-06-17 18:00", "2025-06-18 00:00"},
+		{"the day before yesterday", "", "2025-06-16 00:00", "2025-06-17 00:00"},
+		{"errors in the last 3 days", "errors", "2025-06-16 00:00", "2025-06-19 00:00"},
+		{"3 days ago", "", "2025-06-15 00:00", "2025-06-16 00:00"},
+		{"an hour ago", "", "2025-06-18 14:00", "2025-06-18 15:00"},
+		{"photos from last week", "photos", "2025-06-09 00:00", "2025-06-16 00:00"},
+		{"Saturday night party", "party", "2025-06-14 18:00", "2025-06-15 00:00"},
+		{"wednesday", "", "2025-06-18 00:00", "2025-06-19 00:00"},
+		{"last wednesday", "", "2025-06-11 00:00", "2025-06-12 00:00"},
+		{"no time here", "no time here", "", ""},
+	}
+
+	for _, tt := range tests {
+		rest, timeRange := parseTimeExpression(tt.query, now)
+		if rest != tt.rest {
+			t.Errorf("%q: 查询为 %q，期望 %q", tt.query, rest, tt.rest)
+		}
+		if tt.from == "" {
+			if timeRange != nil {
+				t.Errorf("%q: 期望没有时间范围，得到 [%s, %s)", tt.query, timeRange.FromTime, timeRange.ToTime)
+			}
+			continue
+		}
+		if timeRange == nil {
+			t.Errorf("%q: 没有解析出时间范围，期望 [%s, %s)", tt.query, tt.from, tt.to)
+			continue
+		}
+		if timeRange.FromTime != tt.from || timeRange.ToTime != tt.to {
+			t.Errorf("%q: 时间范围为 [%s, %s)，期望 [%s, %s)", tt.query, timeRange.FromTime, timeRange.ToTime, tt.from, tt.to)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// 多用户：每个用户有自己的文件夹树、对象、分类规则和向量集合，令牌属于某个用户。
+// 根文件夹的 upper 指向自身，默认用户的根文件夹是升级前的 0 号文件夹。
+
+// 默认用户，拥有引入多用户之前的全部数据
+const DefaultUserID = 1
+
+type User struct {
+	ID           int       `json:"id"`
+	Name         string    `json:"name"`
+	RootFolderID int       `json:"root_folder_id"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// 创建用户及其根文件夹
+func createUser(name string) (User, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return User{}, validationError("用户名不能为空")
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users WHERE name = ?", name).Scan(&count); err != nil {
+		return User{}, err
+	}
+	if count > 0 {
+		return User{}, validationError(fmt.Sprintf("用户 '%s' 已存在", name))
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return User{}, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO users (name, root_folder_id, created_at) VALUES (?, 0, CURRENT_TIMESTAMP)", name)
+	if err != nil {
+		return User{}, err
+	}
+	userID, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+
+	result, err = tx.Exec("INSERT INTO folders (name, upper, user_id) VALUES ('Root', 0, ?)", userID)
+	if err != nil {
+		return User{}, err
+	}
+	rootID, err := result.LastInsertId()
+	if err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec("UPDATE folders SET upper = id WHERE id = ?", rootID); err != nil {
+		return User{}, err
+	}
+	if _, err := tx.Exec("UPDATE users SET root_folder_id = ? WHERE id = ?", rootID, userID); err != nil {
+		return User{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		return User{}, err
+	}
+	return getUser(int(userID))
+}
+
+func getUser(id int) (User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, name, root_folder_id, created_at FROM users WHERE id = ?", id).
+		Scan(&user.ID, &user.Name, &user.RootFolderID, &user.CreatedAt)
+	return user, err
+}
+
+func getUserByName(name string) (User, error) {
+	var user User
+	err := db.QueryRow("SELECT id, name, root_folder_id, created_at FROM users WHERE name = ?", name).
+		Scan(&user.ID, &user.Name, &user.RootFolderID, &user.CreatedAt)
+	return user, err
+}
+
+func listUsers() ([]User, error) {
+	rows, err := db.Query("SELECT id, name, root_folder_id, created_at FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Name, &user.RootFolderID, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// 模型推荐无效时存放上传对象的文件夹：INBOX_FOLDER_ID 属于该用户时使用它，否则使用用户的根文件夹
+func userInboxFolder(userID int) (int, error) {
+	exists, err := folderExists(userID, config.InboxFolderID)
+	if err != nil || exists {
+		return config.InboxFolderID, err
+	}
+	user, err := getUser(userID)
+	if err != nil {
+		return 0, err
+	}
+	return user.RootFolderID, nil
+}
+
+// 当前请求的用户，由鉴权中间件根据令牌设置
+func currentUserID(c *gin.Context) int {
+	return c.GetInt("user_id")
+}
+
+// 当前用户信息处理器，前端据此找到自己的根文件夹
+func getCurrentUserHandler(c *gin.Context) {
+	user, err := getUser(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get user: %v", err)})
+		return
+	}
+	token, _ := c.Get("api_token")
+	c.JSON(200, gin.H{"user": user, "token": token})
+}
+
+// 获取用户列表处理器
+func listUsersHandler(c *gin.Context) {
+	users, err := listUsers()
+	if err != nil {
+		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to get users: %v", err)})
+		return
+	}
+	c.JSON(200, gin.H{"users": users})
+}
+
+// 创建用户处理器
+func createUserHandler(c *gin.Context) {
+	var req UserRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid request format"})
+		return
+	}
+
+	user, err := createUser(req.Name)
+	if err != nil {
+		c.JSON(validationErrorStatus(err), gin.H{"error": fmt.Sprintf("Failed to create user: %v", err)})
+		return
+	}
+	c.JSON(200, user)
+}
+
+// 用户是否存在
+func userExists(id int) (bool, error) {
+	_, err := getUser(id)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
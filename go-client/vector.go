@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	chromem "github.com/philippgille/chromem-go"
+)
+
+// 向量文档ID格式：主文档 "<id>"，关键词文档 "<id>_keywords"，问题文档 "<id>_question_<n>"
+
+// 从向量文档ID解析对象ID
+func objectIDFromDocID(docID string) (int, error) {
+	if strings.Contains(docID, "_keywords") {
+		return strconv.Atoi(strings.Replace(docID, "_keywords", "", 1))
+	}
+	if strings.Contains(docID, "_question_") {
+		return strconv.Atoi(strings.Split(docID, "_question_")[0])
+	}
+	return strconv.Atoi(docID)
+}
+
+// 向量文档的元数据，只记录文档类型和所属对象，用于删除对象的全部文档。
+// 文件夹、应用名等过滤条件由SQL判断，不放在元数据中，对象移动时不需要更新向量库
+func vectorMetadata(obj Object, docType string) map[string]string {
+	return map[string]string{
+		"type":      docType,
+		"object_id": strconv.Itoa(obj.ID),
+	}
+}
+
+// 在用户的向量集合中查询最相似的文档
+func queryVectorDB(ctx context.Context, userID int, query string, nResults int) ([]chromem.Result, error) {
+	collection, err := userCollection(userID)
+	if err != nil {
+		return nil, err
+	}
+	docCount := collection.Count()
+	if docCount == 0 || nResults <= 0 {
+		return nil, nil
+	}
+	return collection.Query(ctx, query, min(nResults, docCount), nil, nil)
+}
+
+// 列出用户向量集合中的全部文档
+// chromem 没有按ID读取或遍历文档的接口，用任意文本查询全部文档代替
+func allVectorDocs(ctx context.Context, userID int) ([]chromem.Result, error) {
+	collection, err := userCollection(userID)
+	if err != nil {
+		return nil, err
+	}
+	docCount := collection.Count()
+	if docCount == 0 {
+		return nil, nil
+	}
+	return collection.Query(ctx, "instago", docCount, nil, nil)
+}
+
+// 删除对象的全部向量文档
+func deleteObjectVectors(ctx context.Context, userID, objectID int) error {
+	collection, err := userCollection(userID)
+	if err != nil {
+		return err
+	}
+	id := strconv.Itoa(objectID)
+	// 关键词和问题文档都带 object_id 元数据；旧版本的主文档没有元数据，按ID删除
+	if err := collection.Delete(ctx, map[string]string{"object_id": id}, nil); err != nil {
+		return err
+	}
+	return collection.Delete(ctx, nil, nil, id, id+"_keywords")
+}